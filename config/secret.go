@@ -0,0 +1,21 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Name of the file holding the keys the plugin encrypts stored secrets with.
+const secretKeyFileName = "gotify-relay.key"
+
+// SecretKeyFile returns the path of the key file used to encrypt secrets stored by the plugin.
+// It is kept next to the config file Gotify loaded. Without one it is kept in Gotify's data directory (the parent of the
+// plugins directory) which is persisted by the default Docker setup.
+func SecretKeyFile() string {
+	for _, file := range configFiles() {
+		if _, err := os.Stat(file); err == nil {
+			return filepath.Join(filepath.Dir(file), secretKeyFileName)
+		}
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(Get().PluginsDir)), secretKeyFileName)
+}
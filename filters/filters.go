@@ -0,0 +1,213 @@
+package filters
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+// Compiled form of structs.TransmitterFilters. The zero value allows every message.
+type Filter struct {
+	settings structs.TransmitterFilters
+	// Why the settings could not be built. A filter with an error allows no message.
+	err            error
+	titleInclude   *regexp.Regexp
+	titleExclude   *regexp.Regexp
+	messageInclude *regexp.Regexp
+	messageExclude *regexp.Regexp
+}
+
+func Build(settings structs.TransmitterFilters) (Filter, error) {
+	var filter = Filter{settings: settings}
+	var err error
+
+	if filter.titleInclude, err = compile("title include", settings.TitleInclude); err != nil {
+		return Filter{}, err
+	}
+	if filter.titleExclude, err = compile("title exclude", settings.TitleExclude); err != nil {
+		return Filter{}, err
+	}
+	if filter.messageInclude, err = compile("message include", settings.MessageInclude); err != nil {
+		return Filter{}, err
+	}
+	if filter.messageExclude, err = compile("message exclude", settings.MessageExclude); err != nil {
+		return Filter{}, err
+	}
+	if settings.MinPriority != nil && settings.MaxPriority != nil && *settings.MinPriority > *settings.MaxPriority {
+		return Filter{}, fmt.Errorf("minimum priority %d is greater than maximum priority %d", *settings.MinPriority, *settings.MaxPriority)
+	}
+
+	return filter, nil
+}
+
+// Filter for stored settings that could not be built. It allows no message so a broken rule never lets everything
+// through. The settings are kept so they are saved as they were until they are fixed.
+func Invalid(settings structs.TransmitterFilters, err error) Filter {
+	return Filter{settings: settings, err: err}
+}
+
+// Reports why the settings could not be built. Nil unless the filter was made by Invalid.
+func (filter Filter) Err() error {
+	return filter.err
+}
+
+func compile(name string, expression string) (*regexp.Regexp, error) {
+	if len(expression) == 0 {
+		return nil, nil
+	}
+	compiled, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s regex: %w", name, err)
+	}
+	return compiled, nil
+}
+
+func (filter Filter) Settings() structs.TransmitterFilters {
+	return filter.settings
+}
+
+// Reports whether the message passes every configured rule.
+func (filter Filter) Allows(msg structs.GotifyMessageStruct) bool {
+	var settings = filter.settings
+
+	if filter.err != nil {
+		return false
+	}
+	if len(settings.AllowedApps) > 0 && !slices.Contains(settings.AllowedApps, msg.Appid) {
+		return false
+	}
+	if slices.Contains(settings.DeniedApps, msg.Appid) {
+		return false
+	}
+	if settings.MinPriority != nil && msg.Priority < *settings.MinPriority {
+		return false
+	}
+	if settings.MaxPriority != nil && msg.Priority > *settings.MaxPriority {
+		return false
+	}
+	if filter.titleInclude != nil && !filter.titleInclude.MatchString(msg.Title) {
+		return false
+	}
+	if filter.titleExclude != nil && filter.titleExclude.MatchString(msg.Title) {
+		return false
+	}
+	if filter.messageInclude != nil && !filter.messageInclude.MatchString(msg.Message) {
+		return false
+	}
+	if filter.messageExclude != nil && filter.messageExclude.MatchString(msg.Message) {
+		return false
+	}
+
+	return true
+}
+
+// Reads the filter settings posted by the filter form. The returned settings are validated.
+func ParseForm(ctx *gin.Context) (structs.TransmitterFilters, error) {
+	var settings = structs.TransmitterFilters{
+		TitleInclude:   ctx.PostForm("title-include"),
+		TitleExclude:   ctx.PostForm("title-exclude"),
+		MessageInclude: ctx.PostForm("message-include"),
+		MessageExclude: ctx.PostForm("message-exclude"),
+	}
+	var err error
+
+	if settings.AllowedApps, err = parseIDList(ctx.PostForm("allowed-apps")); err != nil {
+		return settings, fmt.Errorf("allowed applications: %w", err)
+	}
+	if settings.DeniedApps, err = parseIDList(ctx.PostForm("denied-apps")); err != nil {
+		return settings, fmt.Errorf("denied applications: %w", err)
+	}
+	if settings.MinPriority, err = parseOptionalInt(ctx.PostForm("min-priority")); err != nil {
+		return settings, fmt.Errorf("minimum priority: %w", err)
+	}
+	if settings.MaxPriority, err = parseOptionalInt(ctx.PostForm("max-priority")); err != nil {
+		return settings, fmt.Errorf("maximum priority: %w", err)
+	}
+
+	_, err = Build(settings)
+	return settings, err
+}
+
+func parseIDList(value string) ([]int, error) {
+	var ids []int
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid application id", field)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func parseOptionalInt(value string) (*int, error) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return nil, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a number", value)
+	}
+	return &number, nil
+}
+
+func joinIDs(ids []int) string {
+	var parts = make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+	return strings.Join(parts, ", ")
+}
+
+//go:embed form.html
+var form string
+
+// Renders the editable filter section shown within a transmitter card.
+func HTMLForm(id int, settings structs.TransmitterFilters, message string, failed bool) string {
+	templ, err := template.New("").Parse(form)
+	if err != nil {
+		return err.Error()
+	}
+	type temp struct {
+		ID             int
+		AllowedApps    string
+		DeniedApps     string
+		MinPriority    *int
+		MaxPriority    *int
+		TitleInclude   string
+		TitleExclude   string
+		MessageInclude string
+		MessageExclude string
+		Message        string
+		Failed         bool
+	}
+	data := temp{
+		ID:             id,
+		AllowedApps:    joinIDs(settings.AllowedApps),
+		DeniedApps:     joinIDs(settings.DeniedApps),
+		MinPriority:    settings.MinPriority,
+		MaxPriority:    settings.MaxPriority,
+		TitleInclude:   settings.TitleInclude,
+		TitleExclude:   settings.TitleExclude,
+		MessageInclude: settings.MessageInclude,
+		MessageExclude: settings.MessageExclude,
+		Message:        message,
+		Failed:         failed,
+	}
+
+	var buffer = bytes.Buffer{}
+	err = templ.Execute(&buffer, data)
+	if err != nil {
+		return err.Error()
+	}
+	return buffer.String()
+}
@@ -0,0 +1,106 @@
+package filters
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func priority(value int) *int {
+	return &value
+}
+
+func TestAllows(t *testing.T) {
+	var message = structs.GotifyMessageStruct{Appid: 3, Priority: 5, Title: "Backup finished", Message: "Disk usage at 80%"}
+	var tests = []struct {
+		name     string
+		settings structs.TransmitterFilters
+		allowed  bool
+	}{
+		{"no rules", structs.TransmitterFilters{}, true},
+		{"allowed application", structs.TransmitterFilters{AllowedApps: []int{1, 3}}, true},
+		{"application not allowed", structs.TransmitterFilters{AllowedApps: []int{1, 2}}, false},
+		{"denied application", structs.TransmitterFilters{DeniedApps: []int{3}}, false},
+		{"denied wins over allowed", structs.TransmitterFilters{AllowedApps: []int{3}, DeniedApps: []int{3}}, false},
+		{"other application denied", structs.TransmitterFilters{DeniedApps: []int{4}}, true},
+		{"priority at minimum", structs.TransmitterFilters{MinPriority: priority(5)}, true},
+		{"priority below minimum", structs.TransmitterFilters{MinPriority: priority(6)}, false},
+		{"priority at maximum", structs.TransmitterFilters{MaxPriority: priority(5)}, true},
+		{"priority above maximum", structs.TransmitterFilters{MaxPriority: priority(4)}, false},
+		{"priority within range", structs.TransmitterFilters{MinPriority: priority(0), MaxPriority: priority(10)}, true},
+		{"title includes keyword", structs.TransmitterFilters{TitleInclude: "(?i)backup"}, true},
+		{"title misses keyword", structs.TransmitterFilters{TitleInclude: "failed"}, false},
+		{"title excludes keyword", structs.TransmitterFilters{TitleExclude: "finished"}, false},
+		{"message includes keyword", structs.TransmitterFilters{MessageInclude: `\d+%`}, true},
+		{"message misses keyword", structs.TransmitterFilters{MessageInclude: "^Error"}, false},
+		{"message excludes keyword", structs.TransmitterFilters{MessageExclude: "Disk"}, false},
+		{"every rule passes", structs.TransmitterFilters{AllowedApps: []int{3}, MinPriority: priority(1), TitleInclude: "Backup", MessageExclude: "Error"}, true},
+		{"one rule fails", structs.TransmitterFilters{AllowedApps: []int{3}, MinPriority: priority(1), TitleInclude: "Backup", MessageExclude: "Disk"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filter, err := Build(test.settings)
+			require.NoError(t, err)
+			assert.Equal(t, test.allowed, filter.Allows(message))
+		})
+	}
+}
+
+func TestZeroFilterAllows(t *testing.T) {
+	assert.True(t, Filter{}.Allows(structs.GotifyMessageStruct{Title: "anything"}))
+}
+
+func TestInvalidFilterAllowsNothing(t *testing.T) {
+	var settings = structs.TransmitterFilters{TitleInclude: "(unclosed"}
+	var filter = Invalid(settings, errors.New("broken"))
+	assert.False(t, filter.Allows(structs.GotifyMessageStruct{Title: "anything"}))
+	assert.Equal(t, settings, filter.Settings())
+	assert.EqualError(t, filter.Err(), "broken")
+}
+
+func postForm(values url.Values) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+	ctx.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return ctx
+}
+
+func TestParseForm(t *testing.T) {
+	var tests = []struct {
+		name     string
+		form     url.Values
+		settings structs.TransmitterFilters
+		err      string
+	}{
+		{"empty", url.Values{}, structs.TransmitterFilters{}, ""},
+		{"application lists", url.Values{"allowed-apps": {"1, 2,3"}, "denied-apps": {" 4 "}}, structs.TransmitterFilters{AllowedApps: []int{1, 2, 3}, DeniedApps: []int{4}}, ""},
+		{"invalid application", url.Values{"allowed-apps": {"1, two"}}, structs.TransmitterFilters{}, `allowed applications: "two" is not a valid application id`},
+		{"invalid denied application", url.Values{"denied-apps": {"x"}}, structs.TransmitterFilters{}, `denied applications: "x" is not a valid application id`},
+		{"priorities", url.Values{"min-priority": {" 2 "}, "max-priority": {"8"}}, structs.TransmitterFilters{MinPriority: priority(2), MaxPriority: priority(8)}, ""},
+		{"invalid minimum priority", url.Values{"min-priority": {"high"}}, structs.TransmitterFilters{}, `minimum priority: "high" is not a number`},
+		{"invalid maximum priority", url.Values{"max-priority": {"1.5"}}, structs.TransmitterFilters{}, `maximum priority: "1.5" is not a number`},
+		{"minimum above maximum", url.Values{"min-priority": {"8"}, "max-priority": {"2"}}, structs.TransmitterFilters{MinPriority: priority(8), MaxPriority: priority(2)}, "minimum priority 8 is greater than maximum priority 2"},
+		{"keywords", url.Values{"title-include": {"backup"}, "title-exclude": {"test"}, "message-include": {"error|warn"}, "message-exclude": {"debug"}}, structs.TransmitterFilters{TitleInclude: "backup", TitleExclude: "test", MessageInclude: "error|warn", MessageExclude: "debug"}, ""},
+		{"invalid keyword regex", url.Values{"message-exclude": {"("}}, structs.TransmitterFilters{MessageExclude: "("}, "invalid message exclude regex"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings, err := ParseForm(postForm(test.form))
+			if len(test.err) > 0 {
+				assert.ErrorContains(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.settings, settings)
+		})
+	}
+}
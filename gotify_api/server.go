@@ -6,17 +6,19 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
 	"github.com/gorilla/websocket"
 )
 
 type GotifyApi struct {
 	serverUrl    string
 	client_token string
-	logger       *log.Logger
+	logger       *slog.Logger
 }
 
 type GotifyServerInfo struct {
@@ -37,20 +39,25 @@ type GotifyApplication struct {
 }
 
 func SetupGotifyApi(serverUrl string, token string) GotifyApi {
-	return GotifyApi{serverUrl: serverUrl, client_token: token, logger: log.Default()}
+	return GotifyApi{serverUrl: serverUrl, client_token: token, logger: slog.Default()}
 }
 
-func SetupGotifyApiExternalLog(serverUrl string, token string, logger *log.Logger) GotifyApi {
+func SetupGotifyApiExternalLog(serverUrl string, token string, logger *slog.Logger) GotifyApi {
 	return GotifyApi{serverUrl: serverUrl, client_token: token, logger: logger}
 }
 
 func (server *GotifyApi) request(path string, method string, reqBody []byte) ([]byte, error) {
+	return server.requestWithQuery(path, nil, method, reqBody)
+}
+
+func (server *GotifyApi) requestWithQuery(path string, query url.Values, method string, reqBody []byte) ([]byte, error) {
 	var body []byte
 	versionURL, err := url.Parse(server.serverUrl)
 	if err != nil {
 		return body, err
 	}
 	versionURL.Path = path
+	versionURL.RawQuery = query.Encode()
 
 	var reader io.Reader = nil
 	if reqBody != nil {
@@ -174,6 +181,39 @@ func (server *GotifyApi) GetApplication(appId int) (GotifyApplication, error) {
 	return application, fmt.Errorf("application with id of %d not found", appId)
 }
 
+type GotifyPaging struct {
+	Limit int
+	Next  string
+	Since int
+	Size  int
+}
+
+type GotifyPagedMessages struct {
+	Messages []structs.GotifyMessageStruct
+	Paging   GotifyPaging
+}
+
+// Gets a page of messages newest first. Only messages with an ID lower than since are returned. A since of 0 starts at the newest message.
+func (server *GotifyApi) GetMessages(limit int, since int) (GotifyPagedMessages, error) {
+	var page = GotifyPagedMessages{}
+	var query = url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if since > 0 {
+		query.Set("since", strconv.Itoa(since))
+	}
+
+	body, err := server.requestWithQuery("/message", query, http.MethodGet, nil)
+	if err != nil {
+		return page, err
+	}
+	err = json.Unmarshal(body, &page)
+	if err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
 func (server *GotifyApi) CheckToken(token string) error {
 	currentUserURL, err := url.Parse(server.serverUrl)
 	if err != nil {
@@ -218,13 +258,13 @@ type GotifyClientInfo struct {
 func (server *GotifyApi) FindClientFromToken(token string) GotifyClientInfo {
 	body, err := server.request("/client", http.MethodGet, nil)
 	if err != nil {
-		server.logger.Println(err)
+		server.logger.Error(err.Error())
 		return GotifyClientInfo{}
 	}
 	var clients []GotifyClientInfo
 	err = json.Unmarshal(body, &clients)
 	if err != nil {
-		server.logger.Println(err)
+		server.logger.Error(err.Error())
 		return GotifyClientInfo{}
 	}
 
@@ -240,13 +280,13 @@ func (server *GotifyApi) FindClientFromToken(token string) GotifyClientInfo {
 func (server *GotifyApi) FindClientFromName(name string) GotifyClientInfo {
 	body, err := server.request("/client", http.MethodGet, nil)
 	if err != nil {
-		server.logger.Println(err)
+		server.logger.Error(err.Error())
 		return GotifyClientInfo{}
 	}
 	var clients []GotifyClientInfo
 	err = json.Unmarshal(body, &clients)
 	if err != nil {
-		server.logger.Println(err)
+		server.logger.Error(err.Error())
 		return GotifyClientInfo{}
 	}
 
@@ -268,20 +308,20 @@ func (server *GotifyApi) UpdateClient(id int, name string, expireAfterInactivity
 	reqBody, err := json.Marshal(updateClient{Name: name, ExpiresInSeconds: expireAfterInactivitySeconds})
 
 	if err != nil {
-		server.logger.Println(err)
+		server.logger.Error(err.Error())
 		return GotifyClientInfo{}, err
 	}
 
 	body, err := server.request(fmt.Sprintf("/client/%d", id), http.MethodPut, reqBody)
 	if err != nil {
-		server.logger.Println(err)
+		server.logger.Error(err.Error())
 		return GotifyClientInfo{}, err
 	}
 
 	var client GotifyClientInfo
 	err = json.Unmarshal(body, &client)
 	if err != nil {
-		server.logger.Println(err)
+		server.logger.Error(err.Error())
 		return client, err
 	}
 
@@ -291,7 +331,7 @@ func (server *GotifyApi) UpdateClient(id int, name string, expireAfterInactivity
 func (server *GotifyApi) DeleteClient(id int) {
 	_, err := server.request(fmt.Sprintf("/client/%d", id), http.MethodDelete, nil)
 	if err != nil {
-		server.logger.Println(err)
+		server.logger.Error(err.Error())
 		return
 	}
 }
@@ -0,0 +1,182 @@
+// Keeps the most recent log records of a relay in memory so they can be searched from the config page.
+package logging
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Number of records kept by a Buffer unless told otherwise. Older records are dropped.
+const DefaultBufferSize = 2000
+
+// Lowest level kept in the buffer unless told otherwise. Lower than stdout so the config page can show debug records.
+const DefaultBufferLevel = slog.LevelDebug
+
+// Attribute relay records about a single transmitter are tagged with. Holds the transmitter ID.
+const TransmitterKey = "transmitter"
+
+type Attr struct {
+	Key   string
+	Value string
+}
+
+type Entry struct {
+	// Increases with every record. Used as the cursor when paging.
+	Seq     uint64
+	Time    time.Time
+	Level   slog.Level
+	Message string
+	Attrs   []Attr
+}
+
+// Value of the attribute with the given key. Empty if the entry does not have it.
+func (entry Entry) Attr(key string) string {
+	for _, attr := range entry.Attrs {
+		if attr.Key == key {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Fixed size ring of log entries. Safe to use from any goroutine.
+type Buffer struct {
+	lock    sync.Mutex
+	entries []Entry
+	// Index the next entry is written to.
+	next        int
+	lastSeq     uint64
+	subscribers map[chan Entry]struct{}
+}
+
+func NewBuffer(size int) *Buffer {
+	return &Buffer{entries: make([]Entry, 0, max(size, 1))}
+}
+
+func (buffer *Buffer) add(entry Entry) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	buffer.lastSeq++
+	entry.Seq = buffer.lastSeq
+	for subscriber := range buffer.subscribers {
+		select {
+		case subscriber <- entry:
+		default:
+			// Dropped rather than holding up logging. Subscribers can tell from the gap in Seq.
+		}
+	}
+	if len(buffer.entries) < cap(buffer.entries) {
+		buffer.entries = append(buffer.entries, entry)
+		return
+	}
+	buffer.entries[buffer.next] = entry
+	buffer.next = (buffer.next + 1) % len(buffer.entries)
+}
+
+// Size of the channel returned by Subscribe. Entries are dropped for subscribers that fall further behind.
+const subscriberBacklog = 256
+
+// Delivers every entry added from now on through the returned channel until cancel is called.
+// Also returns the buffered entries newer than the after Seq, oldest first, so a subscriber can pick up where it left off.
+// The channel is closed by cancel.
+func (buffer *Buffer) Subscribe(after uint64) (backlog []Entry, entries <-chan Entry, cancel func()) {
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+	for offset := range len(buffer.entries) {
+		var entry = buffer.entries[(buffer.next+offset)%len(buffer.entries)]
+		if entry.Seq > after {
+			backlog = append(backlog, entry)
+		}
+	}
+
+	var subscriber = make(chan Entry, subscriberBacklog)
+	if buffer.subscribers == nil {
+		buffer.subscribers = map[chan Entry]struct{}{}
+	}
+	buffer.subscribers[subscriber] = struct{}{}
+	var once sync.Once
+	return backlog, subscriber, func() {
+		once.Do(func() {
+			buffer.lock.Lock()
+			defer buffer.lock.Unlock()
+			delete(buffer.subscribers, subscriber)
+			close(subscriber)
+		})
+	}
+}
+
+// Selects entries of a Buffer. Zero values match everything.
+type Query struct {
+	// Lowest level included.
+	MinLevel slog.Level
+	// Only entries about the transmitter with this ID.
+	Transmitter string
+	Since       time.Time
+	Until       time.Time
+	// Only entries older than this Seq. Set to Page.Next to get the following page.
+	Before uint64
+	// Most entries returned. Defaults to 100.
+	Limit int
+}
+
+func (query Query) Matches(entry Entry) bool {
+	if entry.Level < query.MinLevel {
+		return false
+	}
+	if len(query.Transmitter) > 0 && entry.Attr(TransmitterKey) != query.Transmitter {
+		return false
+	}
+	if !query.Since.IsZero() && entry.Time.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && entry.Time.After(query.Until) {
+		return false
+	}
+	return query.Before == 0 || entry.Seq < query.Before
+}
+
+type Page struct {
+	// Newest first.
+	Entries []Entry
+	// Before of the query for the next page of older entries. 0 if there are none.
+	Next uint64
+}
+
+// Entries matching the query, newest first.
+func (buffer *Buffer) Query(query Query) Page {
+	if query.Limit <= 0 {
+		query.Limit = 100
+	}
+	buffer.lock.Lock()
+	defer buffer.lock.Unlock()
+
+	var page = Page{Entries: []Entry{}}
+	for offset := 1; offset <= len(buffer.entries); offset++ {
+		var entry = buffer.entries[(buffer.next-offset+len(buffer.entries))%len(buffer.entries)]
+		if !query.Matches(entry) {
+			continue
+		}
+		if len(page.Entries) == query.Limit {
+			page.Next = page.Entries[len(page.Entries)-1].Seq
+			break
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	return page
+}
+
+// Parses the level names used by slog such as "warn" or "ERROR". Empty is the lowest level.
+func ParseLevel(name string) (slog.Level, error) {
+	if len(name) == 0 {
+		return slog.LevelDebug, nil
+	}
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+// Tags a record as being about the transmitter so it can be found with Query.Transmitter.
+func Transmitter(id int) slog.Attr {
+	return slog.Int(TransmitterKey, id)
+}
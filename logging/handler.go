@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+)
+
+// Writes records into a Buffer and passes them on to another handler, usually one writing to stdout.
+// Each decides on its own which levels it wants.
+type Handler struct {
+	buffer *Buffer
+	// Lowest level written into the buffer.
+	level slog.Leveler
+	next  slog.Handler
+	// Attributes added through WithAttrs with their keys already prefixed by the groups.
+	attrs  []Attr
+	groups []string
+}
+
+func NewHandler(buffer *Buffer, level slog.Leveler, next slog.Handler) *Handler {
+	return &Handler{buffer: buffer, level: level, next: next}
+}
+
+// Enabled if either the buffer or the handler records are passed on to wants the level.
+func (handler *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= handler.level.Level() || handler.next.Enabled(ctx, level)
+}
+
+func (handler *Handler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= handler.level.Level() {
+		var entry = Entry{Time: record.Time, Level: record.Level, Message: record.Message, Attrs: slices.Clone(handler.attrs)}
+		record.Attrs(func(attr slog.Attr) bool {
+			entry.Attrs = appendAttr(entry.Attrs, handler.groups, attr)
+			return true
+		})
+		handler.buffer.add(entry)
+	}
+	if !handler.next.Enabled(ctx, record.Level) {
+		return nil
+	}
+	return handler.next.Handle(ctx, record)
+}
+
+func (handler *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var copied = *handler
+	copied.attrs = slices.Clone(handler.attrs)
+	for _, attr := range attrs {
+		copied.attrs = appendAttr(copied.attrs, handler.groups, attr)
+	}
+	copied.next = handler.next.WithAttrs(attrs)
+	return &copied
+}
+
+func (handler *Handler) WithGroup(name string) slog.Handler {
+	if len(name) == 0 {
+		return handler
+	}
+	var copied = *handler
+	copied.groups = append(slices.Clone(handler.groups), name)
+	copied.next = handler.next.WithGroup(name)
+	return &copied
+}
+
+// Flattens groups into dotted keys the way slog.TextHandler does.
+func appendAttr(attrs []Attr, groups []string, attr slog.Attr) []Attr {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return attrs
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if len(attr.Key) > 0 {
+			groups = append(slices.Clone(groups), attr.Key)
+		}
+		for _, member := range attr.Value.Group() {
+			attrs = appendAttr(attrs, groups, member)
+		}
+		return attrs
+	}
+	var key = attr.Key
+	for index := len(groups) - 1; index >= 0; index-- {
+		key = groups[index] + "." + key
+	}
+	return append(attrs, Attr{Key: key, Value: attr.Value.String()})
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerLevels(t *testing.T) {
+	var buffer = NewBuffer(10)
+	var stdout bytes.Buffer
+	var logger = slog.New(NewHandler(buffer, slog.LevelDebug, slog.NewTextHandler(&stdout, nil)))
+
+	logger.Debug("only buffered")
+	logger.Info("everywhere")
+
+	var entries = buffer.Query(Query{MinLevel: slog.LevelDebug}).Entries
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "everywhere", entries[0].Message)
+		assert.Equal(t, "only buffered", entries[1].Message)
+	}
+	assert.NotContains(t, stdout.String(), "only buffered")
+	assert.Contains(t, stdout.String(), "everywhere")
+
+	buffer = NewBuffer(10)
+	stdout.Reset()
+	logger = slog.New(NewHandler(buffer, slog.LevelWarn, slog.NewTextHandler(&stdout, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	logger.Debug("only written")
+	assert.Empty(t, buffer.Query(Query{MinLevel: slog.LevelDebug}).Entries)
+	assert.Contains(t, stdout.String(), "only written")
+}
@@ -0,0 +1,184 @@
+// Minimal markdown handling for destinations that either can not render markdown or expect HTML or their own markup instead.
+// Only covers the subset of markdown commonly found in notifications.
+package markdown
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	codeSpan   = regexp.MustCompile("`([^`]+)`")
+	image      = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)[^)]*\)`)
+	link       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	bold       = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	italic     = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+	strike     = regexp.MustCompile(`~~(.+?)~~`)
+	header     = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	listItem   = regexp.MustCompile(`^(\s*)[*+-]\s+(.*)$`)
+	blockQuote = regexp.MustCompile(`^>\s?(.*)$`)
+)
+
+// Output formats markdown can be converted to.
+type format int
+
+const (
+	plainText format = iota
+	htmlText
+	// Slack's own markup. See https://api.slack.com/reference/surfaces/formatting
+	mrkdwnText
+)
+
+// Converts markdown into HTML using only simple inline tags (b, i, s, code, pre and a).
+// Line breaks are kept as newlines. Suitable for Telegram's HTML parse mode.
+func ToHTML(text string) string {
+	return convert(text, htmlText)
+}
+
+// Removes markdown syntax leaving readable plain text. Link targets are kept after their text.
+func Strip(text string) string {
+	return convert(text, plainText)
+}
+
+// Converts markdown into Slack's mrkdwn. Headers become bold lines as mrkdwn has no headers.
+func ToMrkdwn(text string) string {
+	return convert(text, mrkdwnText)
+}
+
+// Escapes the characters Slack treats as control characters within mrkdwn and plain text.
+func EscapeMrkdwn(text string) string {
+	return mrkdwnEscaper.Replace(text)
+}
+
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func convert(text string, to format) string {
+	var lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	var output = make([]string, 0, len(lines))
+	var inCodeBlock = false
+	var codeBlock []string
+
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCodeBlock {
+				var code = strings.Join(codeBlock, "\n")
+				switch to {
+				case htmlText:
+					code = "<pre>" + html.EscapeString(code) + "</pre>"
+				case mrkdwnText:
+					code = "```\n" + EscapeMrkdwn(code) + "\n```"
+				}
+				output = append(output, code)
+				codeBlock = nil
+			}
+			inCodeBlock = !inCodeBlock
+			continue
+		}
+		if inCodeBlock {
+			codeBlock = append(codeBlock, line)
+			continue
+		}
+		output = append(output, convertLine(line, to))
+	}
+
+	// Unterminated code block. Keep the contents as they were.
+	if inCodeBlock {
+		for _, line := range codeBlock {
+			output = append(output, convertLine(line, to))
+		}
+	}
+
+	return strings.Join(output, "\n")
+}
+
+func convertLine(line string, to format) string {
+	if match := header.FindStringSubmatch(line); match != nil {
+		switch to {
+		case htmlText:
+			return "<b>" + convertInline(match[1], to) + "</b>"
+		case mrkdwnText:
+			return "*" + convertInline(match[1], to) + "*"
+		}
+		return convertInline(match[1], to)
+	}
+	if match := listItem.FindStringSubmatch(line); match != nil {
+		return match[1] + "• " + convertInline(match[2], to)
+	}
+	if match := blockQuote.FindStringSubmatch(line); match != nil {
+		if to == mrkdwnText {
+			return "> " + convertInline(match[1], to)
+		}
+		return convertInline(match[1], to)
+	}
+	return convertInline(line, to)
+}
+
+// Converts inline markup. Content of code spans is left untouched.
+func convertInline(line string, to format) string {
+	var builder strings.Builder
+	var last = 0
+	for _, span := range codeSpan.FindAllStringSubmatchIndex(line, -1) {
+		builder.WriteString(convertSegment(line[last:span[0]], to))
+		var code = line[span[2]:span[3]]
+		switch to {
+		case htmlText:
+			builder.WriteString("<code>" + html.EscapeString(code) + "</code>")
+		case mrkdwnText:
+			builder.WriteString("`" + EscapeMrkdwn(code) + "`")
+		default:
+			builder.WriteString(code)
+		}
+		last = span[1]
+	}
+	builder.WriteString(convertSegment(line[last:], to))
+	return builder.String()
+}
+
+// Schemes links are kept for. Others such as javascript: could run code where the converted text is shown.
+var linkSchemes = []string{"http", "https", "tg"}
+
+// Replaces the links matched by expression with template. Links with any other scheme than linkSchemes are
+// replaced with their text alone.
+func replaceLinks(segment string, expression *regexp.Regexp, template string) string {
+	return expression.ReplaceAllStringFunc(segment, func(match string) string {
+		var groups = expression.FindStringSubmatchIndex(match)
+		var scheme, _, found = strings.Cut(match[groups[4]:groups[5]], ":")
+		if !found || !slices.Contains(linkSchemes, strings.ToLower(scheme)) {
+			return match[groups[2]:groups[3]]
+		}
+		return string(expression.ExpandString(nil, template, match, groups))
+	})
+}
+
+// Stands in for the asterisks of bold text in mrkdwn so they are not taken for italic markup.
+const mrkdwnBold = "\x00"
+
+func convertSegment(segment string, to format) string {
+	switch to {
+	case htmlText:
+		segment = html.EscapeString(segment)
+		segment = replaceLinks(segment, image, `<a href="$2">$1</a>`)
+		segment = replaceLinks(segment, link, `<a href="$2">$1</a>`)
+		segment = bold.ReplaceAllString(segment, "<b>$1$2</b>")
+		segment = italic.ReplaceAllString(segment, "<i>$1$2</i>")
+		segment = strike.ReplaceAllString(segment, "<s>$1</s>")
+		return segment
+	case mrkdwnText:
+		segment = EscapeMrkdwn(segment)
+		segment = replaceLinks(segment, image, "<$2|$1>")
+		segment = replaceLinks(segment, link, "<$2|$1>")
+		segment = bold.ReplaceAllString(segment, mrkdwnBold+"$1$2"+mrkdwnBold)
+		segment = italic.ReplaceAllString(segment, "_${1}${2}_")
+		segment = strike.ReplaceAllString(segment, "~$1~")
+		return strings.ReplaceAll(segment, mrkdwnBold, "*")
+	}
+
+	segment = image.ReplaceAllString(segment, "$1 ($2)")
+	segment = link.ReplaceAllString(segment, "$1 ($2)")
+	segment = bold.ReplaceAllString(segment, "$1$2")
+	segment = italic.ReplaceAllString(segment, "$1$2")
+	segment = strike.ReplaceAllString(segment, "$1")
+	return segment
+}
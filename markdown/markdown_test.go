@@ -0,0 +1,104 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToHTML(t *testing.T) {
+	var tests = []struct {
+		name     string
+		markdown string
+		html     string
+	}{
+		{"plain text is escaped", `a < b & "c"`, `a &lt; b &amp; &#34;c&#34;`},
+		{"bold", "**bold** and __bold__", "<b>bold</b> and <b>bold</b>"},
+		{"italic", "*italic* and _italic_", "<i>italic</i> and <i>italic</i>"},
+		{"italic within bold", "**bold *italic* bold**", "<b>bold <i>italic</i> bold</b>"},
+		{"strike", "~~gone~~", "<s>gone</s>"},
+		{"code span is left untouched", "run `**rm** <x>` now", "run <code>**rm** &lt;x&gt;</code> now"},
+		{"header", "## Title ##", "<b>Title</b>"},
+		{"list item", "  - item", "  • item"},
+		{"block quote", "> quoted", "quoted"},
+		{"https link", "[site](https://example.org/a?b=1&c=2)", `<a href="https://example.org/a?b=1&amp;c=2">site</a>`},
+		{"telegram link", "[chat](tg://resolve?domain=x)", `<a href="tg://resolve?domain=x">chat</a>`},
+		{"image", "![logo](http://example.org/logo.png)", `<a href="http://example.org/logo.png">logo</a>`},
+		{"javascript link", "[click](javascript:alert(1))", "click)"},
+		{"javascript link in capitals", "[click](JavaScript:alert)", "click"},
+		{"data image", "![x](data:text/html;base64,AAAA)", "x"},
+		{"link without scheme", "[page](/relative)", "page"},
+		{"code block", "```\n<b>**x**</b>\n```", "<pre>&lt;b&gt;**x**&lt;/b&gt;</pre>"},
+		{"unterminated code block", "```\n**bold**", "<b>bold</b>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.html, ToHTML(test.markdown))
+		})
+	}
+}
+
+func TestStrip(t *testing.T) {
+	var tests = []struct {
+		name     string
+		markdown string
+		plain    string
+	}{
+		{"formatting", "**bold** *italic* ~~strike~~", "bold italic strike"},
+		{"italic within bold", "**bold _italic_ bold**", "bold italic bold"},
+		{"header", "# Title", "Title"},
+		{"link keeps target", "[site](https://example.org)", "site (https://example.org)"},
+		{"image keeps target", "![logo](https://example.org/logo.png)", "logo (https://example.org/logo.png)"},
+		{"code span", "use `**x**`", "use **x**"},
+		{"code block", "```\n**x**\n```", "**x**"},
+		{"unterminated code block", "```\n**x**", "x"},
+		{"html is kept", "<b>", "<b>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.plain, Strip(test.markdown))
+		})
+	}
+}
+
+func TestToMrkdwn(t *testing.T) {
+	var tests = []struct {
+		name     string
+		markdown string
+		mrkdwn   string
+	}{
+		{"bold", "**bold**", "*bold*"},
+		{"italic", "*italic*", "_italic_"},
+		{"italic within bold", "**bold *italic* bold**", "*bold _italic_ bold*"},
+		{"strike", "~~gone~~", "~gone~"},
+		{"header", "### Title", "*Title*"},
+		{"block quote", "> quoted", "> quoted"},
+		{"control characters are escaped", "a < b & c > d", "a &lt; b &amp; c &gt; d"},
+		{"link", "[site](https://example.org)", "<https://example.org|site>"},
+		{"javascript link", "[click](javascript:alert)", "click"},
+		{"code span", "`<*x*>`", "`&lt;*x*&gt;`"},
+		{"code block", "```\n**x** <y>\n```", "```\n**x** &lt;y&gt;\n```"},
+		{"unterminated code block", "```\n**x**", "*x*"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.mrkdwn, ToMrkdwn(test.markdown))
+		})
+	}
+}
+
+func TestEscapeMrkdwn(t *testing.T) {
+	var tests = []struct {
+		text    string
+		escaped string
+	}{
+		{"plain", "plain"},
+		{"<!channel>", "&lt;!channel&gt;"},
+		{"a & b", "a &amp; b"},
+		{"&amp;", "&amp;amp;"},
+		{"*not bold*", "*not bold*"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.escaped, EscapeMrkdwn(test.text), test.text)
+	}
+}
@@ -0,0 +1,231 @@
+// Minimal metrics collection written out in the Prometheus text exposition format.
+// Only covers what the relay needs: labelled counters, histograms and values read when scraped.
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Content type of the output of Registry.Write.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Bucket upper bounds in seconds suited to HTTP requests.
+var DefaultDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+type collector interface {
+	write(buffer *bytes.Buffer)
+}
+
+// Holds every metric of a relay in the order they were registered.
+type Registry struct {
+	lock       sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) register(metric collector) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+	registry.collectors = append(registry.collectors, metric)
+}
+
+// Writes every metric in the Prometheus text exposition format.
+func (registry *Registry) Write(writer io.Writer) error {
+	registry.lock.Lock()
+	var collectors = slices.Clone(registry.collectors)
+	registry.lock.Unlock()
+
+	var buffer bytes.Buffer
+	for _, metric := range collectors {
+		metric.write(&buffer)
+	}
+	_, err := writer.Write(buffer.Bytes())
+	return err
+}
+
+type description struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (desc description) writeHeader(buffer *bytes.Buffer) {
+	var help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(desc.help)
+	buffer.WriteString("# HELP " + desc.name + " " + help + "\n")
+	buffer.WriteString("# TYPE " + desc.name + " " + desc.kind + "\n")
+}
+
+// Writes a single sample. extra holds additional label name and value pairs such as le for histogram buckets.
+func (desc description) writeSample(buffer *bytes.Buffer, suffix string, labelValues []string, value float64, extra ...string) {
+	buffer.WriteString(desc.name + suffix)
+	var names = slices.Clone(desc.labels)
+	var values = slices.Clone(labelValues)
+	for index := 0; index+1 < len(extra); index += 2 {
+		names = append(names, extra[index])
+		values = append(values, extra[index+1])
+	}
+	if len(names) > 0 {
+		buffer.WriteByte('{')
+		for index, name := range names {
+			if index > 0 {
+				buffer.WriteByte(',')
+			}
+			buffer.WriteString(name + `="` + escapeLabel(values[index]) + `"`)
+		}
+		buffer.WriteByte('}')
+	}
+	buffer.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Joins label values into a map key. Label values never contain the separator in practice.
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+// Counters sharing a name, one per combination of label values.
+type CounterVec struct {
+	desc   description
+	lock   sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labelValues []string
+	value       float64
+}
+
+func (registry *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	var counter = &CounterVec{desc: description{name: name, help: help, kind: "counter", labels: labels}, series: map[string]*counterSeries{}}
+	registry.register(counter)
+	return counter
+}
+
+// Adds to the counter with the given label values. Values must be given in the order the labels were registered.
+func (counter *CounterVec) Add(value float64, labelValues ...string) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	var key = seriesKey(labelValues)
+	var series = counter.series[key]
+	if series == nil {
+		series = &counterSeries{labelValues: slices.Clone(labelValues)}
+		counter.series[key] = series
+	}
+	series.value += value
+}
+
+func (counter *CounterVec) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+func (counter *CounterVec) write(buffer *bytes.Buffer) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+	counter.desc.writeHeader(buffer)
+	for _, key := range slices.Sorted(maps.Keys(counter.series)) {
+		counter.desc.writeSample(buffer, "", counter.series[key].labelValues, counter.series[key].value)
+	}
+}
+
+// Histograms sharing a name and buckets, one per combination of label values.
+type HistogramVec struct {
+	desc    description
+	buckets []float64
+	lock    sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	// Observations per bucket. Not cumulative.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// buckets are the upper bounds of the buckets in increasing order. A +Inf bucket is always added.
+func (registry *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	var histogram = &HistogramVec{desc: description{name: name, help: help, kind: "histogram", labels: labels}, buckets: slices.Clone(buckets), series: map[string]*histogramSeries{}}
+	registry.register(histogram)
+	return histogram
+}
+
+func (histogram *HistogramVec) Observe(value float64, labelValues ...string) {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+	var key = seriesKey(labelValues)
+	var series = histogram.series[key]
+	if series == nil {
+		series = &histogramSeries{labelValues: slices.Clone(labelValues), counts: make([]uint64, len(histogram.buckets))}
+		histogram.series[key] = series
+	}
+	if index, _ := slices.BinarySearch(histogram.buckets, value); index < len(histogram.buckets) {
+		series.counts[index]++
+	}
+	series.sum += value
+	series.count++
+}
+
+func (histogram *HistogramVec) write(buffer *bytes.Buffer) {
+	histogram.lock.Lock()
+	defer histogram.lock.Unlock()
+	histogram.desc.writeHeader(buffer)
+	for _, key := range slices.Sorted(maps.Keys(histogram.series)) {
+		var series = histogram.series[key]
+		var cumulative uint64
+		for index, bound := range histogram.buckets {
+			cumulative += series.counts[index]
+			histogram.desc.writeSample(buffer, "_bucket", series.labelValues, float64(cumulative), "le", formatFloat(bound))
+		}
+		histogram.desc.writeSample(buffer, "_bucket", series.labelValues, float64(series.count), "le", "+Inf")
+		histogram.desc.writeSample(buffer, "_sum", series.labelValues, series.sum)
+		histogram.desc.writeSample(buffer, "_count", series.labelValues, float64(series.count))
+	}
+}
+
+// Values read when the metrics are written. Used for state kept elsewhere such as queue lengths.
+type funcCollector struct {
+	desc    description
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+// collect is called on every scrape and reports each value through emit.
+func (registry *Registry) NewGaugeFunc(name string, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	registry.register(&funcCollector{desc: description{name: name, help: help, kind: "gauge", labels: labels}, collect: collect})
+}
+
+// Like NewGaugeFunc for values that only ever increase, such as counts kept elsewhere.
+func (registry *Registry) NewCounterFunc(name string, help string, labels []string, collect func(emit func(value float64, labelValues ...string))) {
+	registry.register(&funcCollector{desc: description{name: name, help: help, kind: "counter", labels: labels}, collect: collect})
+}
+
+func (metric *funcCollector) write(buffer *bytes.Buffer) {
+	metric.desc.writeHeader(buffer)
+	metric.collect(func(value float64, labelValues ...string) {
+		metric.desc.writeSample(buffer, "", labelValues, value)
+	})
+}
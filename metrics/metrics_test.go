@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func write(t *testing.T, registry *Registry) string {
+	var builder strings.Builder
+	assert.NoError(t, registry.Write(&builder))
+	return builder.String()
+}
+
+func TestCounterOutput(t *testing.T) {
+	var registry = NewRegistry()
+	var counter = registry.NewCounterVec("relay_deliveries_total", "Deliveries by transmitter.\nCounts retries too.", "transmitter", "result")
+	counter.Inc("2", "success")
+	counter.Add(2.5, "1", "failure")
+	counter.Inc("2", "success")
+
+	assert.Equal(t, `# HELP relay_deliveries_total Deliveries by transmitter.\nCounts retries too.
+# TYPE relay_deliveries_total counter
+relay_deliveries_total{transmitter="1",result="failure"} 2.5
+relay_deliveries_total{transmitter="2",result="success"} 2
+`, write(t, registry))
+}
+
+func TestLabelEscaping(t *testing.T) {
+	var registry = NewRegistry()
+	var counter = registry.NewCounterVec("relay_errors_total", `Errors with C:\ paths`, "error")
+	counter.Inc("say \"hi\"\nC:\\relay")
+
+	assert.Equal(t, `# HELP relay_errors_total Errors with C:\\ paths
+# TYPE relay_errors_total counter
+relay_errors_total{error="say \"hi\"\nC:\\relay"} 1
+`, write(t, registry))
+}
+
+func TestHistogramOutput(t *testing.T) {
+	var registry = NewRegistry()
+	var histogram = registry.NewHistogramVec("relay_delivery_seconds", "Time taken by deliveries.", []float64{0.1, 1}, "type")
+	histogram.Observe(0.05, "discord")
+	// Bounds are inclusive.
+	histogram.Observe(1, "discord")
+	histogram.Observe(4, "discord")
+
+	assert.Equal(t, `# HELP relay_delivery_seconds Time taken by deliveries.
+# TYPE relay_delivery_seconds histogram
+relay_delivery_seconds_bucket{type="discord",le="0.1"} 1
+relay_delivery_seconds_bucket{type="discord",le="1"} 2
+relay_delivery_seconds_bucket{type="discord",le="+Inf"} 3
+relay_delivery_seconds_sum{type="discord"} 5.05
+relay_delivery_seconds_count{type="discord"} 3
+`, write(t, registry))
+}
+
+func TestFuncCollectorsAndOrder(t *testing.T) {
+	var registry = NewRegistry()
+	registry.NewGaugeFunc("relay_outbox_jobs", "Jobs waiting.", nil, func(emit func(value float64, labelValues ...string)) {
+		emit(3)
+	})
+	registry.NewCounterFunc("relay_sent_total", "Messages sent.", []string{"transmitter"}, func(emit func(value float64, labelValues ...string)) {
+		emit(7, "1")
+	})
+	registry.NewCounterVec("relay_unused_total", "Never incremented.")
+
+	assert.Equal(t, `# HELP relay_outbox_jobs Jobs waiting.
+# TYPE relay_outbox_jobs gauge
+relay_outbox_jobs 3
+# HELP relay_sent_total Messages sent.
+# TYPE relay_sent_total counter
+relay_sent_total{transmitter="1"} 7
+# HELP relay_unused_total Never incremented.
+# TYPE relay_unused_total counter
+`, write(t, registry))
+}
@@ -1,16 +1,15 @@
 package main
 
 import (
-	"bytes"
 	_ "embed"
-	"io"
-	"log"
+	"log/slog"
 	"net/url"
 	"os"
 	"strconv"
 
 	"github.com/CEKlopfenstein/gotify-repeater/config"
 	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/logging"
 	"github.com/CEKlopfenstein/gotify-repeater/relay"
 	"github.com/CEKlopfenstein/gotify-repeater/storage"
 	"github.com/CEKlopfenstein/gotify-repeater/structs"
@@ -43,15 +42,15 @@ func GetGotifyPluginInfo() plugin.Info {
 
 // GotifyRelayPlugin is the gotify plugin instance.
 type GotifyRelayPlugin struct {
-	userCtx   plugin.UserContext
-	config    *structs.Config
-	relay     relay.Relay
-	basePath  string
-	hostName  string
-	storage   storage.Storage
-	enabled   bool
-	logger    *log.Logger
-	logBuffer *bytes.Buffer
+	userCtx  plugin.UserContext
+	config   *structs.Config
+	relay    relay.Relay
+	basePath string
+	hostName string
+	storage  storage.Storage
+	enabled  bool
+	logger   *slog.Logger
+	logs     *logging.Buffer
 }
 
 // Enable enables the plugin.
@@ -61,9 +60,9 @@ func (c *GotifyRelayPlugin) Enable() error {
 	c.relay.SetUserName(c.userCtx.Name)
 	c.relay.SetGotifyApi(server)
 	c.relay.SetLogger(c.logger)
-	c.relay.SetStorage(c.storage)
-	go c.relay.Start()
-	c.logger.Printf("Plugin Enabled for %s\n", c.userCtx.Name)
+	c.relay.SetStorage(&c.storage)
+	c.relay.Start()
+	c.logger.Info("Plugin Enabled for " + c.userCtx.Name)
 	return nil
 }
 
@@ -71,7 +70,7 @@ func (c *GotifyRelayPlugin) Enable() error {
 func (c *GotifyRelayPlugin) Disable() error {
 	c.enabled = false
 	c.relay.Stop()
-	c.logger.Printf("Plugin Disabled for %s\n", c.userCtx.Name)
+	c.logger.Info("Plugin Disabled for " + c.userCtx.Name)
 	return nil
 }
 
@@ -80,6 +79,14 @@ func (c *GotifyRelayPlugin) GetDisplay(location *url.URL) string {
 
 	toReturn += "## Version: " + info.Version + "\n\n## Description:\n" + info.Description + "\n\n"
 
+	if err := c.storage.LoadError(); err != nil {
+		toReturn += "**WARNING: The stored data could not be read. Changes are not being saved until this is fixed.**\n\nError: " + err.Error() + "\n\n"
+	}
+
+	if status := storage.GetSecretKeyStatus(); len(status.Error) > 0 {
+		toReturn += "**WARNING: Secrets are stored unencrypted. No key could be read from the " + status.Source + ".**\n\nError: " + status.Error + "\n\n"
+	}
+
 	if len(c.storage.GetClientToken()) == 0 {
 		toReturn += "Missing Token. Go to Config Page to setup.\n\n"
 	}
@@ -96,7 +103,7 @@ func (c *GotifyRelayPlugin) GetDisplay(location *url.URL) string {
 
 func (c *GotifyRelayPlugin) RegisterWebhook(basePath string, mux *gin.RouterGroup) {
 	c.basePath = basePath
-	user_interface.BuildInterface(basePath, mux, &c.relay, c.config, c.storage, c.hostName, c.logger, c.logBuffer)
+	user_interface.BuildInterface(basePath, mux, &c.relay, c.config, &c.storage, c.hostName, c.logger, c.logs)
 }
 
 func (c *GotifyRelayPlugin) SetStorageHandler(h plugin.StorageHandler) {
@@ -126,14 +133,16 @@ func NewGotifyPluginInstance(ctx plugin.UserContext) plugin.Plugin {
 		host += ":" + strconv.Itoa(conf.Server.Port)
 	}
 
-	logBuffer := &(bytes.Buffer{})
-	logger := log.New(io.MultiWriter(os.Stdout, logBuffer), "Gotify Relay: ", log.LstdFlags|log.Lmsgprefix)
-	logger.Printf("Logger Successfully Created for %s", ctx.Name)
+	// Records are kept in a fixed size buffer for the config page and written to stdout.
+	logs := logging.NewBuffer(logging.DefaultBufferSize)
+	stdout := slog.NewTextHandler(os.Stdout, nil).WithAttrs([]slog.Attr{slog.String("plugin", info.Name), slog.String("user", ctx.Name)})
+	logger := slog.New(logging.NewHandler(logs, logging.DefaultBufferLevel, stdout))
+	logger.Info("Logger Successfully Created for " + ctx.Name)
 
-	toReturn := &GotifyRelayPlugin{userCtx: ctx, hostName: host, logger: logger, logBuffer: logBuffer}
+	toReturn := &GotifyRelayPlugin{userCtx: ctx, hostName: host, logger: logger, logs: logs}
 	toReturn.storage.Logger = logger
 	for tType := range transmitters.Types {
-		transmitters.Types[tType].SetGlobalLogger(logger)
+		transmitters.Types[tType].SetGlobalLogger(logger.With("transmitterType", tType))
 	}
 
 	return toReturn
@@ -0,0 +1,71 @@
+package relay
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+)
+
+const backfillPageSize = 100
+
+// Relays messages posted while the stream was disconnected. Oldest first. Returns the ID of the newest message relayed,
+// or 0 if there was none, so the stream can skip the ones it also received.
+// Nothing is relayed if a page of missed messages can not be fetched. Relaying only the newer pages would move the
+// last relayed ID past the older ones so they would never be caught up on.
+func (relay *Relay) backfill() (int, error) {
+	var lastId = relay.storage.GetLastMessageId()
+	var settings = relay.storage.GetSettings()
+	if lastId == 0 || settings.MaxBackfillMinutes <= 0 {
+		return 0, nil
+	}
+	var cutoff = time.Now().Add(-time.Duration(settings.MaxBackfillMinutes) * time.Minute)
+
+	var missed []structs.GotifyMessageStruct
+	var since = 0
+	var server = relay.GetGotifyApi()
+paging:
+	for {
+		page, err := server.GetMessages(backfillPageSize, since)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get missed messages: %w", err)
+		}
+		if since == 0 && (len(page.Messages) == 0 || page.Messages[0].Id < lastId) {
+			// Gotify hands out IDs from 1 again after its database was reset. Counting from the newest message it has
+			// lets the next reconnect catch up on the messages after it.
+			var newest = 0
+			if len(page.Messages) > 0 {
+				newest = page.Messages[0].Id
+			}
+			relay.logger.Warn(fmt.Sprintf("Newest Gotify message %d is older than the last relayed message %d. The Gotify database was probably reset", newest, lastId), "user", relay.userName)
+			relay.storage.ResetLastMessageId(newest)
+			return 0, nil
+		}
+		for _, msg := range page.Messages {
+			if msg.Id <= lastId {
+				break paging
+			}
+			date, err := time.Parse(time.RFC3339, msg.Date)
+			if err == nil && date.Before(cutoff) {
+				break paging
+			}
+			missed = append(missed, msg)
+		}
+		if len(page.Messages) < backfillPageSize || page.Paging.Since == 0 {
+			break
+		}
+		since = page.Paging.Since
+	}
+
+	if len(missed) == 0 {
+		return 0, nil
+	}
+	relay.logger.Info(fmt.Sprintf("Relaying %d message(s) missed while disconnected", len(missed)), "user", relay.userName)
+	slices.Reverse(missed)
+	for _, msg := range missed {
+		relay.countReceived(sourceBackfill)
+		relay.relayMessage(msg)
+	}
+	return missed[len(missed)-1].Id, nil
+}
@@ -0,0 +1,114 @@
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+// Keeps the stored data of the relay in memory.
+type memoryStorageHandler struct {
+	lock sync.Mutex
+	data []byte
+}
+
+func (handler *memoryStorageHandler) Save(data []byte) error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.data = data
+	return nil
+}
+
+func (handler *memoryStorageHandler) Load() ([]byte, error) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.data, nil
+}
+
+// Relay with a single fake transmitter and messages fetched from the handler.
+func newBackfillRelay(t *testing.T, lastMessageId int, gotify http.HandlerFunc) (*Relay, *fakeTransmitter) {
+	var server = httptest.NewServer(gotify)
+	t.Cleanup(server.Close)
+	var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var relay = &Relay{logger: logger}
+	relay.storage = &storage.Storage{StorageHandler: &memoryStorageHandler{}, Logger: logger}
+	relay.storage.SaveSettings(storage.DefaultSettings())
+	relay.storage.SaveLastMessageId(lastMessageId)
+	relay.SetGotifyApi(gotify_api.SetupGotifyApiExternalLog(server.URL, "client-token", logger))
+	var fake = &fakeTransmitter{status: true, delivered: make(chan structs.GotifyMessageStruct, 1000)}
+	relay.transmitters = map[int]*lockedTransmitter{1: newLockedTransmitter(fake)}
+	return relay, fake
+}
+
+// Page of messages with IDs from newest down to oldest.
+func messagePage(newest int, oldest int) gotify_api.GotifyPagedMessages {
+	var page = gotify_api.GotifyPagedMessages{Paging: gotify_api.GotifyPaging{Since: oldest}}
+	for id := newest; id >= oldest; id-- {
+		page.Messages = append(page.Messages, structs.GotifyMessageStruct{Id: id, Message: "missed", Date: time.Now().Format(time.RFC3339)})
+	}
+	page.Paging.Size = len(page.Messages)
+	return page
+}
+
+func TestBackfillRelaysOldestFirst(t *testing.T) {
+	relay, fake := newBackfillRelay(t, 50, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("since") == "" {
+			json.NewEncoder(w).Encode(messagePage(249, 150))
+			return
+		}
+		json.NewEncoder(w).Encode(messagePage(149, 40))
+	})
+
+	caughtUp, err := relay.backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, 249, caughtUp)
+	assert.Len(t, fake.delivered, 199)
+	assert.Equal(t, 51, (<-fake.delivered).Id)
+	assert.Equal(t, 249, relay.storage.GetLastMessageId())
+}
+
+func TestBackfillRelaysNothingWhenAPageFails(t *testing.T) {
+	relay, fake := newBackfillRelay(t, 50, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("since") {
+		case "":
+			json.NewEncoder(w).Encode(messagePage(349, 250))
+		case "250":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			json.NewEncoder(w).Encode(messagePage(249, 40))
+		}
+	})
+
+	_, err := relay.backfill()
+	assert.Error(t, err)
+	assert.Empty(t, fake.delivered)
+	// Left alone so the next connect catches up on the whole gap.
+	assert.Equal(t, 50, relay.storage.GetLastMessageId())
+}
+
+func TestBackfillAfterGotifyDatabaseReset(t *testing.T) {
+	relay, fake := newBackfillRelay(t, 500, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(messagePage(3, 1))
+	})
+
+	caughtUp, err := relay.backfill()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, caughtUp)
+	assert.Empty(t, fake.delivered)
+	assert.Equal(t, 3, relay.storage.GetLastMessageId())
+
+	relay.relayMessage(structs.GotifyMessageStruct{Id: 4, Message: "live"})
+	assert.Len(t, fake.delivered, 1)
+	assert.Equal(t, 4, relay.storage.GetLastMessageId())
+}
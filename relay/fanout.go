@@ -0,0 +1,141 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/filters"
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/CEKlopfenstein/gotify-repeater/transmitters"
+)
+
+const (
+	// Number of transmitters a message is delivered through at the same time.
+	fanOutWorkers = 4
+	// Longest a single delivery may take. A delivery that times out is retried through the outbox.
+	transmitTimeout = 30 * time.Second
+)
+
+// Wraps a transmitter so only one goroutine uses it at a time. Transmitters keep state such as their transmit count
+// and are shared between the stream, the outbox and the user interface.
+// The status and transmit count are kept here instead of within the wrapped transmitter. So they can be read and changed
+// while a delivery holds the lock for the whole request.
+type lockedTransmitter struct {
+	lock  sync.Mutex
+	inner transmitters.Transmitter
+	// Kept for labelling metrics without locking. Never changes as updates must keep the type.
+	transmitterType string
+	active          atomic.Bool
+	count           atomic.Int64
+	// Storage value of inner. Its ID, status and transmit count are filled in by GetStorageValue.
+	stored atomic.Pointer[structs.TransmitterStorage]
+}
+
+func newLockedTransmitter(inner transmitters.Transmitter) *lockedTransmitter {
+	var trans = &lockedTransmitter{inner: inner, transmitterType: inner.GetStorageValue(0).TransmitterType}
+	trans.active.Store(inner.Active())
+	trans.count.Store(int64(inner.GetTransmitCount()))
+	trans.setInner(inner)
+	return trans
+}
+
+// Swaps in a transmitter with new settings. Must be called with the lock held unless trans is not shared yet.
+func (trans *lockedTransmitter) setInner(inner transmitters.Transmitter) {
+	var stored = inner.GetStorageValue(0)
+	trans.inner = inner
+	trans.stored.Store(&stored)
+}
+
+// The wrapped transmitter only learns of status changes when its card is rendered.
+func (trans *lockedTransmitter) HTMLCard(id int) string {
+	trans.lock.Lock()
+	defer trans.lock.Unlock()
+	trans.inner.SetStatus(trans.active.Load())
+	return trans.inner.HTMLCard(id)
+}
+
+func (trans *lockedTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	var stored = *trans.stored.Load()
+	stored.Id = id
+	stored.Active = trans.Active()
+	stored.TransmitCount = trans.GetTransmitCount()
+	return stored
+}
+
+func (trans *lockedTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	trans.lock.Lock()
+	defer trans.lock.Unlock()
+	var err = trans.inner.Transmit(ctx, msg, server)
+	// Skipped messages return structs.ErrSkipped and are not counted.
+	if err == nil {
+		trans.count.Add(1)
+	}
+	return err
+}
+
+func (trans *lockedTransmitter) Active() bool {
+	return trans.active.Load()
+}
+
+func (trans *lockedTransmitter) SetStatus(active bool) {
+	trans.active.Store(active)
+}
+
+func (trans *lockedTransmitter) GetTransmitCount() int {
+	return int(trans.count.Load())
+}
+
+// Renders a preview if the wrapped transmitter supports it.
+func (trans *lockedTransmitter) preview(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) (string, bool, error) {
+	trans.lock.Lock()
+	defer trans.lock.Unlock()
+	previewer, ok := trans.inner.(transmitters.Previewer)
+	if !ok {
+		return "", false, nil
+	}
+	preview, err := previewer.Preview(msg, server)
+	return preview, true, err
+}
+
+// Sends the message through a single transmitter. Gives up once transmitTimeout has passed.
+// A message the transmitter skipped on purpose counts as neither sent nor failed.
+func (relay *Relay) transmit(ctx context.Context, id int, transmitter *lockedTransmitter, msg structs.GotifyMessageStruct) error {
+	ctx, cancel := context.WithTimeout(ctx, transmitTimeout)
+	defer cancel()
+	var started = time.Now()
+	var err = transmitter.Transmit(ctx, msg, relay.GetGotifyApi())
+	if errors.Is(err, structs.ErrSkipped) {
+		return nil
+	}
+	relay.recordDelivery(id, transmitter.transmitterType, started, err)
+	return err
+}
+
+// Delivers the message through every active transmitter whose filters allow it. At most fanOutWorkers deliveries run
+// at once. Failed deliveries are queued in the outbox. Returns once every delivery finished. Reports if any transmitter was used.
+func (relay *Relay) fanOut(msg structs.GotifyMessageStruct, current map[int]*lockedTransmitter, currentFilters map[int]filters.Filter) bool {
+	var workers = make(chan struct{}, fanOutWorkers)
+	var wait sync.WaitGroup
+	var used atomic.Bool
+
+	for id, transmitter := range current {
+		workers <- struct{}{}
+		wait.Go(func() {
+			defer func() { <-workers }()
+			if !transmitter.Active() || !currentFilters[id].Allows(msg) {
+				return
+			}
+			used.Store(true)
+			if err := relay.transmit(context.Background(), id, transmitter, msg); err != nil {
+				relay.enqueueFailure(id, msg, err)
+			}
+		})
+	}
+
+	wait.Wait()
+	return used.Load()
+}
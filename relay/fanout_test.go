@@ -0,0 +1,116 @@
+package relay
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/filters"
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Transmitter that delivers into a channel. Blocks until release is closed if it is set.
+type fakeTransmitter struct {
+	status    bool
+	count     int
+	release   chan struct{}
+	delivered chan structs.GotifyMessageStruct
+	// Skips every message like the gotify transmitter does for federated messages.
+	skip bool
+}
+
+func (trans *fakeTransmitter) HTMLCard(id int) string { return "" }
+
+func (trans *fakeTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	return structs.TransmitterStorage{Id: id, TransmitterType: "fake", Active: trans.status, TransmitCount: trans.count}
+}
+
+func (trans *fakeTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	if trans.skip {
+		return structs.ErrSkipped
+	}
+	if trans.release != nil {
+		<-trans.release
+	}
+	trans.delivered <- msg
+	trans.count++
+	return nil
+}
+
+func (trans *fakeTransmitter) Active() bool          { return trans.status }
+func (trans *fakeTransmitter) SetStatus(active bool) { trans.status = active }
+func (trans *fakeTransmitter) GetTransmitCount() int { return trans.count }
+
+func TestLockedTransmitterStateDuringDelivery(t *testing.T) {
+	var fake = &fakeTransmitter{status: true, count: 2, release: make(chan struct{}), delivered: make(chan structs.GotifyMessageStruct, 1)}
+	var transmitter = newLockedTransmitter(fake)
+	go transmitter.Transmit(context.Background(), structs.GotifyMessageStruct{Id: 1}, gotify_api.GotifyApi{})
+
+	// None of these may wait for the delivery holding the lock.
+	var answered = make(chan structs.TransmitterStorage)
+	go func() {
+		transmitter.SetStatus(false)
+		answered <- transmitter.GetStorageValue(5)
+	}()
+	select {
+	case stored := <-answered:
+		assert.Equal(t, structs.TransmitterStorage{Id: 5, TransmitterType: "fake", Active: false, TransmitCount: 2}, stored)
+		assert.False(t, transmitter.Active())
+	case <-time.After(time.Second):
+		t.Fatal("status and storage value waited for the delivery")
+	}
+
+	close(fake.release)
+	<-fake.delivered
+	assert.Eventually(t, func() bool { return transmitter.GetTransmitCount() == 3 }, time.Second, time.Millisecond)
+}
+
+func TestFanOutNotHeldUpBySlowTransmitter(t *testing.T) {
+	var slow = &fakeTransmitter{status: true, release: make(chan struct{}), delivered: make(chan structs.GotifyMessageStruct, 2)}
+	var fast = &fakeTransmitter{status: true, delivered: make(chan structs.GotifyMessageStruct, 2)}
+	var inactive = &fakeTransmitter{status: false, delivered: make(chan structs.GotifyMessageStruct, 2)}
+	var current = map[int]*lockedTransmitter{1: newLockedTransmitter(slow), 2: newLockedTransmitter(fast), 3: newLockedTransmitter(inactive)}
+	var relay = &Relay{}
+
+	// Another delivery already holds the slow transmitter, like a retry from the outbox would.
+	go current[1].Transmit(context.Background(), structs.GotifyMessageStruct{Id: 1}, gotify_api.GotifyApi{})
+	var used = make(chan bool)
+	go func() {
+		used <- relay.fanOut(structs.GotifyMessageStruct{Id: 2, Message: "Hi"}, current, map[int]filters.Filter{})
+	}()
+
+	select {
+	case msg := <-fast.delivered:
+		assert.Equal(t, 2, msg.Id)
+	case <-time.After(time.Second):
+		t.Fatal("fast transmitter waited for the slow one")
+	}
+	close(slow.release)
+	assert.True(t, <-used)
+	assert.Len(t, slow.delivered, 2)
+	assert.Empty(t, inactive.delivered)
+}
+
+func TestSkippedDeliveryNotCounted(t *testing.T) {
+	var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	var skipping = &fakeTransmitter{status: true, skip: true}
+	var current = map[int]*lockedTransmitter{1: newLockedTransmitter(skipping)}
+	var relay = &Relay{logger: logger}
+	relay.storage = &storage.Storage{StorageHandler: &memoryStorageHandler{}, Logger: logger}
+
+	relay.fanOut(structs.GotifyMessageStruct{Id: 1}, current, map[int]filters.Filter{})
+	assert.Equal(t, 0, current[1].GetTransmitCount())
+	assert.Empty(t, relay.storage.GetOutbox())
+
+	var written strings.Builder
+	require.NoError(t, relay.WriteMetrics(&written))
+	assert.NotContains(t, written.String(), "gotify_relay_transmitter_sent_total{")
+	assert.NotContains(t, written.String(), "gotify_relay_transmitter_failed_total{")
+}
@@ -0,0 +1,108 @@
+package relay
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/metrics"
+)
+
+// Sources a message can be received from.
+const (
+	sourceStream   = "stream"
+	sourceBackfill = "backfill"
+)
+
+type relayMetrics struct {
+	registry  *metrics.Registry
+	received  *metrics.CounterVec
+	sent      *metrics.CounterVec
+	failed    *metrics.CounterVec
+	retried   *metrics.CounterVec
+	durations *metrics.HistogramVec
+}
+
+var streamStates = []StreamState{StateStopped, StateConnecting, StateConnected, StateBackingOff}
+
+// Created on first use as a Relay is used without a constructor.
+func (relay *Relay) metrics() *relayMetrics {
+	relay.metricsOnce.Do(func() {
+		var registry = metrics.NewRegistry()
+		relay.metricsData = &relayMetrics{
+			registry: registry,
+			received: registry.NewCounterVec("gotify_relay_messages_received_total",
+				"Messages received from Gotify. source is stream for live messages and backfill for messages caught up on after reconnecting.", "source"),
+			sent: registry.NewCounterVec("gotify_relay_transmitter_sent_total",
+				"Messages delivered by a transmitter.", "transmitter_id", "transmitter_type"),
+			failed: registry.NewCounterVec("gotify_relay_transmitter_failed_total",
+				"Deliveries by a transmitter that failed. Includes failed retries.", "transmitter_id", "transmitter_type"),
+			retried: registry.NewCounterVec("gotify_relay_transmitter_retried_total",
+				"Deliveries retried from the outbox or replayed from the dead letters.", "transmitter_id", "transmitter_type"),
+			durations: registry.NewHistogramVec("gotify_relay_transmitter_delivery_duration_seconds",
+				"Time taken by a transmitter to deliver a message whether it succeeded or not.", metrics.DefaultDurationBuckets, "transmitter_id", "transmitter_type"),
+		}
+
+		registry.NewGaugeFunc("gotify_relay_stream_state", "Current state of the connection to the Gotify stream. 1 for the current state, 0 for the others.", []string{"state"},
+			func(emit func(float64, ...string)) {
+				var current = relay.GetStreamStatus().State
+				for _, state := range streamStates {
+					var value = 0.0
+					if state == current {
+						value = 1
+					}
+					emit(value, string(state))
+				}
+			})
+		registry.NewCounterFunc("gotify_relay_stream_reconnects_total", "Times the connection to the Gotify stream was lost and opened again.", nil,
+			func(emit func(float64, ...string)) {
+				emit(float64(relay.GetStreamStatus().Reconnects))
+			})
+		registry.NewGaugeFunc("gotify_relay_outbox_depth", "Failed deliveries waiting in the outbox to be retried.", nil,
+			func(emit func(float64, ...string)) {
+				emit(float64(len(relay.storage.GetOutbox())))
+			})
+		registry.NewGaugeFunc("gotify_relay_dead_letters", "Deliveries that gave up and wait to be replayed or purged.", nil,
+			func(emit func(float64, ...string)) {
+				emit(float64(len(relay.storage.GetDeadLetters())))
+			})
+		registry.NewGaugeFunc("gotify_relay_transmitter_active", "1 if the transmitter is enabled, 0 if it is disabled.", []string{"transmitter_id", "transmitter_type"},
+			func(emit func(float64, ...string)) {
+				current, _ := relay.snapshot()
+				for _, id := range slices.Sorted(maps.Keys(current)) {
+					var value = 0.0
+					if current[id].Active() {
+						value = 1
+					}
+					emit(value, strconv.Itoa(id), current[id].transmitterType)
+				}
+			})
+	})
+	return relay.metricsData
+}
+
+func (relay *Relay) countReceived(source string) {
+	relay.metrics().received.Inc(source)
+}
+
+func (relay *Relay) recordDelivery(id int, transmitterType string, started time.Time, err error) {
+	var labels = []string{strconv.Itoa(id), transmitterType}
+	var collected = relay.metrics()
+	collected.durations.Observe(time.Since(started).Seconds(), labels...)
+	if err != nil {
+		collected.failed.Inc(labels...)
+	} else {
+		collected.sent.Inc(labels...)
+	}
+}
+
+func (relay *Relay) countRetry(id int, transmitterType string) {
+	relay.metrics().retried.Inc(strconv.Itoa(id), transmitterType)
+}
+
+// Writes the metrics of the relay in the Prometheus text format. Content type is metrics.ContentType.
+func (relay *Relay) WriteMetrics(writer io.Writer) error {
+	return relay.metrics().registry.Write(writer)
+}
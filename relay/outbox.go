@@ -0,0 +1,184 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/logging"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+)
+
+const (
+	// Number of attempts (including the first delivery) before a job is dead lettered.
+	outboxMaxAttempts = 8
+	outboxBaseDelay   = 10 * time.Second
+	outboxMaxDelay    = time.Hour
+	outboxPollPeriod  = 5 * time.Second
+)
+
+// Retries failed deliveries until the context is cancelled. Pending jobs live in storage so they survive the plugin being disabled.
+func (relay *Relay) runOutbox(ctx context.Context) {
+	var ticker = time.NewTicker(outboxPollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			relay.processOutbox()
+		}
+	}
+}
+
+// Records a failed delivery so it can be retried later.
+func (relay *Relay) enqueueFailure(transmitterId int, msg structs.GotifyMessageStruct, err error) {
+	var now = time.Now()
+	var job = structs.OutboxJob{
+		TransmitterId: transmitterId,
+		Message:       msg,
+		Attempts:      1,
+		LastError:     err.Error(),
+		Created:       now,
+		LastAttempt:   now,
+		NextAttempt:   now.Add(outboxBackoff(1, err)),
+	}
+	var id = relay.storage.AddOutboxJob(job)
+	relay.logger.Warn(fmt.Sprintf("Transmitter %d failed to deliver message %d. Queued as job %d", transmitterId, msg.Id, id), logging.Transmitter(transmitterId), "error", err)
+}
+
+func (relay *Relay) processOutbox() {
+	var now = time.Now()
+	var retried = false
+	current, _ := relay.snapshot()
+
+	for _, job := range relay.storage.GetOutbox() {
+		if job.NextAttempt.After(now) {
+			continue
+		}
+
+		var transmitter = current[job.TransmitterId]
+		if transmitter == nil {
+			job.LastError = "transmitter no longer exists"
+			relay.storage.DeadLetterOutboxJob(job)
+			relay.logger.Error(fmt.Sprintf("Outbox job %d dead lettered: transmitter %d no longer exists", job.Id, job.TransmitterId), logging.Transmitter(job.TransmitterId))
+			continue
+		}
+		if !transmitter.Active() {
+			continue
+		}
+
+		relay.countRetry(job.TransmitterId, transmitter.transmitterType)
+		var err = relay.transmit(context.Background(), job.TransmitterId, transmitter, job.Message)
+		retried = true
+
+		if err == nil {
+			relay.storage.RemoveOutboxJob(job.Id)
+			relay.logger.Info(fmt.Sprintf("Outbox job %d delivered by transmitter %d after %d attempts", job.Id, job.TransmitterId, job.Attempts+1), logging.Transmitter(job.TransmitterId))
+			continue
+		}
+
+		job.Attempts++
+		job.LastError = err.Error()
+		job.LastAttempt = time.Now()
+		if job.Attempts >= outboxMaxAttempts {
+			relay.storage.DeadLetterOutboxJob(job)
+			relay.logger.Error(fmt.Sprintf("Outbox job %d dead lettered after %d attempts", job.Id, job.Attempts), logging.Transmitter(job.TransmitterId), "error", err)
+			continue
+		}
+		job.NextAttempt = job.LastAttempt.Add(outboxBackoff(job.Attempts, err))
+		relay.storage.UpdateOutboxJob(job)
+	}
+
+	if retried {
+		relay.saveTransmitters()
+	}
+}
+
+// Delay before the next attempt. Doubles with every attempt up to outboxMaxDelay with up to half of it randomised.
+// Never shorter than a delay requested by the destination through structs.RetryAfterError.
+func outboxBackoff(attempts int, err error) time.Duration {
+	var delay = outboxMaxDelay
+	if attempts < 20 {
+		delay = min(outboxBaseDelay<<(attempts-1), outboxMaxDelay)
+	}
+	delay = delay/2 + rand.N(delay/2)
+
+	var retryAfter *structs.RetryAfterError
+	if errors.As(err, &retryAfter) && retryAfter.RetryAfter > delay {
+		return retryAfter.RetryAfter
+	}
+	return delay
+}
+
+func (relay *Relay) GetDeadLetters() []structs.OutboxJob {
+	return relay.storage.GetDeadLetters()
+}
+
+// Sends a dead letter through the given transmitter. It is removed from the dead letter list on success.
+func (relay *Relay) ReplayDeadLetter(jobId int, transmitterId int) error {
+	var deadLetters = relay.storage.GetDeadLetters()
+	var index = slices.IndexFunc(deadLetters, func(job structs.OutboxJob) bool { return job.Id == jobId })
+	if index == -1 {
+		return fmt.Errorf("dead letter %d not found", jobId)
+	}
+	var err = relay.replay(deadLetters[index], transmitterId)
+	relay.saveTransmitters()
+	return err
+}
+
+// Replays every dead letter that was originally meant for the transmitter.
+func (relay *Relay) ReplayDeadLetters(transmitterId int) (delivered int, failed int) {
+	for _, job := range relay.storage.GetDeadLetters() {
+		if job.TransmitterId != transmitterId {
+			continue
+		}
+		if relay.replay(job, transmitterId) == nil {
+			delivered++
+		} else {
+			failed++
+		}
+	}
+	relay.saveTransmitters()
+	return delivered, failed
+}
+
+func (relay *Relay) replay(job structs.OutboxJob, transmitterId int) error {
+	current, _ := relay.snapshot()
+	var transmitter = current[transmitterId]
+	if transmitter == nil {
+		return fmt.Errorf("transmitter %d not found", transmitterId)
+	}
+
+	relay.countRetry(transmitterId, transmitter.transmitterType)
+	var err = relay.transmit(context.Background(), transmitterId, transmitter, job.Message)
+
+	if err == nil {
+		relay.storage.RemoveDeadLetters(func(stored structs.OutboxJob) bool { return stored.Id == job.Id })
+		relay.logger.Info(fmt.Sprintf("Dead letter %d replayed through transmitter %d", job.Id, transmitterId), logging.Transmitter(transmitterId))
+		return nil
+	}
+
+	job.Attempts++
+	job.LastError = err.Error()
+	job.LastAttempt = time.Now()
+	relay.storage.UpdateDeadLetter(job)
+	relay.logger.Warn(fmt.Sprintf("Replay of dead letter %d through transmitter %d failed", job.Id, transmitterId), logging.Transmitter(transmitterId), "error", err)
+	return err
+}
+
+func (relay *Relay) PurgeDeadLetter(jobId int) int {
+	return relay.storage.RemoveDeadLetters(func(job structs.OutboxJob) bool { return job.Id == jobId })
+}
+
+func (relay *Relay) PurgeAllDeadLetters() int {
+	return relay.storage.RemoveDeadLetters(func(job structs.OutboxJob) bool { return true })
+}
+
+// Removes every dead letter meant for the transmitter.
+func (relay *Relay) PurgeDeadLetters(transmitterId int) int {
+	return relay.storage.RemoveDeadLetters(func(job structs.OutboxJob) bool { return job.TransmitterId == transmitterId })
+}
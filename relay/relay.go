@@ -1,10 +1,15 @@
 package relay
 
 import (
-	"log"
-	"time"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
 
+	"github.com/CEKlopfenstein/gotify-repeater/filters"
 	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/logging"
 	"github.com/CEKlopfenstein/gotify-repeater/storage"
 	"github.com/CEKlopfenstein/gotify-repeater/structs"
 	"github.com/CEKlopfenstein/gotify-repeater/transmitters"
@@ -12,15 +17,36 @@ import (
 )
 
 type Relay struct {
-	listener     *websocket.Conn
-	gotifyApi    gotify_api.GotifyApi
-	transmitters map[int]transmitters.Transmitter
-	storage      storage.Storage
-	userName     string
-	logger       *log.Logger
+	apiLock   sync.RWMutex
+	gotifyApi gotify_api.GotifyApi
+	// Guards transmitters and transmitterFilters. Both maps are replaced instead of modified.
+	// So a snapshot taken while holding the lock can be used after releasing it.
+	lock               sync.RWMutex
+	transmitters       map[int]*lockedTransmitter
+	transmitterFilters map[int]filters.Filter
+	// Stored transmitters that could not be rehydrated. Kept as they are so saving never loses them.
+	unreadable map[int]structs.TransmitterStorage
+	// Serialises saving the transmitters so an older snapshot never overwrites a newer one.
+	saveLock sync.Mutex
+	storage  *storage.Storage
+	userName string
+	logger   *slog.Logger
+
+	// Stream supervisor
+	cancel     context.CancelFunc
+	done       chan struct{}
+	reconnect  chan struct{}
+	statusLock sync.Mutex
+	status     StreamStatus
+	listener   *websocket.Conn
+
+	metricsOnce sync.Once
+	metricsData *relayMetrics
 }
 
 func (relay *Relay) SetGotifyApi(gotifyApi gotify_api.GotifyApi) {
+	relay.apiLock.Lock()
+	defer relay.apiLock.Unlock()
 	relay.gotifyApi = gotifyApi
 }
 
@@ -28,80 +54,91 @@ func (relay *Relay) SetUserName(userName string) {
 	relay.userName = userName
 }
 
-func (relay *Relay) SetStorage(storage storage.Storage) {
+func (relay *Relay) SetStorage(storage *storage.Storage) {
 	relay.storage = storage
 	relay.loadTransmitters()
 }
 
-func (relay *Relay) SetLogger(logger *log.Logger) {
+func (relay *Relay) SetLogger(logger *slog.Logger) {
 	relay.logger = logger
 }
 func (relay *Relay) GetGotifyApi() gotify_api.GotifyApi {
+	relay.apiLock.RLock()
+	defer relay.apiLock.RUnlock()
 	return relay.gotifyApi
 }
 
-func (relay *Relay) Start() {
-	var attemptTick = -1
-	var attemptLimit = 100
-	for {
-		if attemptTick >= attemptLimit {
-			relay.logger.Printf("%s Limit Exceeded for checking HTTP(s) status\n", relay.userName)
-			return
-		}else{
-			relay.logger.Printf("%s Checking HTTP(s) Status in %dms", relay.userName, (100 * (2+attemptTick)))
-		}
-		time.Sleep(time.Duration(100 * (attemptTick+2)) * time.Millisecond)
-		attemptTick++
-		_, check := relay.gotifyApi.GetServerInfo()
-		if check == nil {
-			break
-		}
-		relay.logger.Printf("%s Checking HTTP(s) Status. Error: %s\n", relay.userName, check.Error())
-	}
-	err := relay.connectToStream()
-	if err != nil {
-		relay.logger.Printf("%s Failed to make connection to stream. Error: %s\n", relay.userName, err.Error())
-		return
-	}
-	relay.logger.Printf("Relay for %s connected to stream.\n", relay.userName)
-	relay.startSender()
-}
-
 func (relay *Relay) UpdateToken(token string) error {
 	relay.storage.SaveClientToken(token)
+	relay.apiLock.Lock()
 	err := relay.gotifyApi.UpdateToken(token)
+	relay.apiLock.Unlock()
 	if err != nil {
 		return err
 	}
-	err = relay.connectToStream()
-	if err != nil {
-		return err
-	}
+	relay.forceReconnect()
 	return nil
 }
 
-func (relay *Relay) connectToStream() error {
-	if relay.listener != nil {
-		relay.logger.Printf("%s Active Connection Found. Now closing.\n", relay.userName)
-		var err = relay.Stop()
-		if err != nil {
-			return err
-		}
+// Current transmitters and their filters. The returned maps must not be modified.
+func (relay *Relay) snapshot() (map[int]*lockedTransmitter, map[int]filters.Filter) {
+	relay.lock.RLock()
+	defer relay.lock.RUnlock()
+	return relay.transmitters, relay.transmitterFilters
+}
+
+// Replaces the transmitters and filters with modified copies.
+func (relay *Relay) update(modify func(current map[int]*lockedTransmitter, currentFilters map[int]filters.Filter)) {
+	relay.lock.Lock()
+	defer relay.lock.Unlock()
+	var current = maps.Clone(relay.transmitters)
+	var currentFilters = maps.Clone(relay.transmitterFilters)
+	if current == nil {
+		current = map[int]*lockedTransmitter{}
 	}
-	listener, err := relay.gotifyApi.GetStream()
-	if err != nil {
-		return err
+	if currentFilters == nil {
+		currentFilters = map[int]filters.Filter{}
 	}
-	relay.listener = listener
-	return nil
+	modify(current, currentFilters)
+	relay.transmitters = current
+	relay.transmitterFilters = currentFilters
 }
 
 func (relay *Relay) loadTransmitters() {
-	relay.transmitters = map[int]transmitters.Transmitter{}
+	var loaded = map[int]*lockedTransmitter{}
+	var loadedFilters = map[int]filters.Filter{}
+	var unreadable = map[int]structs.TransmitterStorage{}
 	var transFromStore = relay.storage.GetTransmitters()
+	var migrated = 0
 
 	for key := range transFromStore {
-		relay.transmitters[key] = transmitters.RehydrateTransmitter(transFromStore[key])
+		transmitter, err := transmitters.RehydrateTransmitter(transFromStore[key])
+		if err != nil {
+			relay.logger.Error(fmt.Sprintf("Transmitter %d is disabled until its stored settings are fixed", key), logging.Transmitter(key), "error", err)
+			unreadable[key] = transFromStore[key]
+			continue
+		}
+		if transmitter.GetStorageValue(key).ConfigVersion != transFromStore[key].ConfigVersion {
+			migrated++
+		}
+		loaded[key] = newLockedTransmitter(transmitter)
+		filter, err := filters.Build(transFromStore[key].Filters)
+		if err != nil {
+			relay.logger.Error(fmt.Sprintf("Transmitter %d relays nothing until its invalid filters are fixed", key), logging.Transmitter(key), "error", err)
+			filter = filters.Invalid(transFromStore[key].Filters, err)
+		}
+		loadedFilters[key] = filter
+	}
+
+	relay.lock.Lock()
+	relay.transmitters = loaded
+	relay.transmitterFilters = loadedFilters
+	relay.unreadable = unreadable
+	relay.lock.Unlock()
+
+	if migrated > 0 {
+		relay.logger.Info(fmt.Sprintf("Migrated the stored settings of %d transmitter(s) to the current config version", migrated))
+		relay.saveTransmitters()
 	}
 }
 
@@ -110,52 +147,43 @@ func (relay *Relay) ReloadTransmitters() {
 }
 
 func (relay *Relay) saveTransmitters() {
-	transmitters := relay.GetTransmitters()
-	var transToStore = map[int]structs.TransmitterStorage{}
-	for key := range transmitters {
-		transToStore[key] = transmitters[key].GetStorageValue(key)
+	relay.saveLock.Lock()
+	defer relay.saveLock.Unlock()
+
+	current, currentFilters := relay.snapshot()
+	relay.lock.RLock()
+	var transToStore = maps.Clone(relay.unreadable)
+	relay.lock.RUnlock()
+	if transToStore == nil {
+		transToStore = map[int]structs.TransmitterStorage{}
+	}
+	for key := range current {
+		var stored = current[key].GetStorageValue(key)
+		stored.Filters = currentFilters[key].Settings()
+		transToStore[key] = stored
 	}
 	relay.storage.SaveTransmitters(transToStore)
 }
 
-func (relay *Relay) startSender() {
-	go func() {
-		var con = relay.listener
-		defer con.Close()
-		for {
-			var gotifyMessage = structs.GotifyMessageStruct{}
-			var err = con.ReadJSON(&gotifyMessage)
-			if relay.listener == nil {
-				relay.logger.Printf("Connection Closed for %s Relay.\n", relay.userName)
-				return
-			}
-			if err != nil {
-				relay.logger.Printf("Failed to read in Gotify Message from %s Stream: %s\n", relay.userName, err.Error())
-				relay.Stop()
-				go func() {
-					time.Sleep(time.Second * 1)
-					go relay.Start()
-				}()
-			}
-
-			if len(gotifyMessage.Message)+len(gotifyMessage.Title) == 0 {
-				continue
-			}
-
-			var activeFlag = false
-
-			for key := range relay.transmitters {
-				if relay.transmitters[key].Active() {
-					activeFlag = true
-					relay.transmitters[key].Transmit(gotifyMessage, relay.gotifyApi)
-				}
-			}
-
-			if activeFlag {
-				relay.saveTransmitters()
-			}
-		}
-	}()
+// Saves every transmitter and the client token again so their secrets are encrypted with the current key.
+// Transmitters whose settings could not be read are kept as they are.
+func (relay *Relay) ReencryptSecrets() {
+	relay.saveTransmitters()
+}
+
+// Fans the message out to every active transmitter whose filters allow it.
+func (relay *Relay) relayMessage(gotifyMessage structs.GotifyMessageStruct) {
+	if len(gotifyMessage.Message)+len(gotifyMessage.Title) == 0 {
+		return
+	}
+
+	current, currentFilters := relay.snapshot()
+	var activeFlag = relay.fanOut(gotifyMessage, current, currentFilters)
+
+	relay.storage.SaveLastMessageId(gotifyMessage.Id)
+	if activeFlag {
+		relay.saveTransmitters()
+	}
 }
 
 func (relay *Relay) AddTransmitter(sender transmitters.Transmitter) int {
@@ -166,49 +194,130 @@ func (relay *Relay) AddTransmitter(sender transmitters.Transmitter) int {
 }
 
 func (relay *Relay) ClearTransmitters() int {
-	if relay.transmitters == nil {
-		relay.transmitters = make(map[int]transmitters.Transmitter)
-	}
-	count := len(relay.transmitters)
-
-	for key := range relay.transmitters {
-		delete(relay.transmitters, key)
-	}
+	var count = 0
+	relay.update(func(current map[int]*lockedTransmitter, currentFilters map[int]filters.Filter) {
+		count = len(current)
+		clear(current)
+		clear(currentFilters)
+		relay.unreadable = nil
+	})
 	relay.saveTransmitters()
 	return count
 }
 
 func (relay *Relay) RemoveTransmitter(index int) {
-	if relay.transmitters == nil {
-		relay.transmitters = make(map[int]transmitters.Transmitter)
-	}
-	delete(relay.transmitters, index)
+	relay.update(func(current map[int]*lockedTransmitter, currentFilters map[int]filters.Filter) {
+		delete(current, index)
+		delete(currentFilters, index)
+		delete(relay.unreadable, index)
+	})
 	relay.saveTransmitters()
 }
 
+// Returns a copy of the current transmitters. Each is safe to use from any goroutine.
 func (relay *Relay) GetTransmitters() map[int]transmitters.Transmitter {
-	if relay.transmitters == nil {
-		relay.transmitters = make(map[int]transmitters.Transmitter)
+	current, _ := relay.snapshot()
+	var copied = make(map[int]transmitters.Transmitter, len(current))
+	for key, transmitter := range current {
+		copied[key] = transmitter
 	}
-
-	return relay.transmitters
+	return copied
 }
 
 func (relay *Relay) SetTransmitterStatus(id int, status bool) {
-	relay.transmitters[id].SetStatus(status)
+	current, _ := relay.snapshot()
+	var transmitter = current[id]
+	if transmitter == nil {
+		return
+	}
+	transmitter.SetStatus(status)
 	relay.saveTransmitters()
 }
 
-func (relay *Relay) Stop() error {
+// Replaces the settings of an existing transmitter. Its ID, type, status, transmit count and filters are kept.
+func (relay *Relay) UpdateTransmitter(id int, stored structs.TransmitterStorage) error {
+	current, _ := relay.snapshot()
+	var transmitter = current[id]
+	if transmitter == nil {
+		return fmt.Errorf("transmitter %d not found", id)
+	}
+
+	var existing = transmitter.GetStorageValue(id)
+	if existing.TransmitterType != stored.TransmitterType {
+		return fmt.Errorf("transmitter %d is a %s transmitter not a %s transmitter", id, existing.TransmitterType, stored.TransmitterType)
+	}
+	stored.Id = id
+	stored.Active = existing.Active
+	stored.TransmitCount = existing.TransmitCount
+	// Rehydrating may contact the destination. Done before taking the lock so deliveries are not held up by it.
+	updated, err := transmitters.RehydrateTransmitter(stored)
+	if err != nil {
+		return err
+	}
+
+	// Swapped in place so deliveries already waiting on the transmitter use the new settings.
+	transmitter.lock.Lock()
+	transmitter.setInner(updated)
+	transmitter.lock.Unlock()
+
 	relay.saveTransmitters()
-	if relay.listener != nil {
-		var con = relay.listener
-		relay.listener = nil
-		var err = con.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-		if err != nil {
-			relay.logger.Printf("Error while closing %s connection: %s\n", relay.userName, err.Error())
-		}
+	return nil
+}
+
+// Sends the message through a single transmitter immediately. Failures are returned instead of being queued for retry.
+// Also returns the response status reported by the destination if there was one.
+func (relay *Relay) TestTransmitter(id int, msg structs.GotifyMessageStruct) (string, error) {
+	current, _ := relay.snapshot()
+	var transmitter = current[id]
+	if transmitter == nil {
+		return "", fmt.Errorf("transmitter %d not found", id)
+	}
+
+	ctx, recorder := structs.WithResponseRecorder(context.Background())
+	var err = relay.transmit(ctx, id, transmitter, msg)
+	relay.saveTransmitters()
+	return recorder.Status, err
+}
+
+// Renders what the transmitter would send for the message. Only supported by transmitters implementing transmitters.Previewer.
+func (relay *Relay) PreviewTransmitter(id int, msg structs.GotifyMessageStruct) (string, error) {
+	current, _ := relay.snapshot()
+	var transmitter = current[id]
+	if transmitter == nil {
+		return "", fmt.Errorf("transmitter %d not found", id)
+	}
+	preview, supported, err := transmitter.preview(msg, relay.GetGotifyApi())
+	if !supported {
+		return "", fmt.Errorf("transmitter %d does not support previews", id)
+	}
+	return preview, err
+}
+
+func (relay *Relay) GetTransmitterFilters(id int) structs.TransmitterFilters {
+	_, currentFilters := relay.snapshot()
+	return currentFilters[id].Settings()
+}
+
+// Reports why the stored filters of a transmitter could not be built. Nothing is relayed through it until they are replaced.
+func (relay *Relay) GetTransmitterFilterError(id int) error {
+	_, currentFilters := relay.snapshot()
+	return currentFilters[id].Err()
+}
+
+func (relay *Relay) SetTransmitterFilters(id int, settings structs.TransmitterFilters) error {
+	filter, err := filters.Build(settings)
+	if err != nil {
 		return err
 	}
+	var found bool
+	relay.update(func(current map[int]*lockedTransmitter, currentFilters map[int]filters.Filter) {
+		if _, found = current[id]; found {
+			currentFilters[id] = filter
+		}
+	})
+	if !found {
+		return fmt.Errorf("transmitter %d not found", id)
+	}
+	relay.saveTransmitters()
 	return nil
 }
@@ -0,0 +1,50 @@
+package relay
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvalidStoredFiltersAreKept(t *testing.T) {
+	var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	var stored = &storage.Storage{StorageHandler: &memoryStorageHandler{}, Logger: logger}
+	var invalid = structs.TransmitterFilters{AllowedApps: []int{2}, TitleInclude: "(unclosed"}
+	var id = stored.AddTransmitter(structs.TransmitterStorage{TransmitterType: "log", Active: true, Filters: invalid})
+
+	var relay = &Relay{logger: logger}
+	relay.SetStorage(stored)
+	require.Len(t, relay.GetTransmitters(), 1)
+	assert.Equal(t, invalid, relay.GetTransmitterFilters(id))
+
+	require.Error(t, relay.GetTransmitterFilterError(id))
+	current, currentFilters := relay.snapshot()
+	assert.False(t, currentFilters[id].Allows(structs.GotifyMessageStruct{Appid: 2, Title: "(unclosed"}))
+	assert.False(t, relay.fanOut(structs.GotifyMessageStruct{Appid: 2}, current, currentFilters))
+
+	relay.SetTransmitterStatus(id, false)
+	relay.saveTransmitters()
+	assert.Equal(t, invalid, stored.GetTransmitters()[id].Filters)
+
+	var fixed = structs.TransmitterFilters{AllowedApps: []int{2}, TitleInclude: "closed"}
+	require.NoError(t, relay.SetTransmitterFilters(id, fixed))
+	assert.Equal(t, fixed, relay.GetTransmitterFilters(id))
+	assert.Equal(t, fixed, stored.GetTransmitters()[id].Filters)
+	assert.NoError(t, relay.GetTransmitterFilterError(id))
+}
+
+func TestSetFiltersOfUnknownTransmitter(t *testing.T) {
+	var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	var stored = &storage.Storage{StorageHandler: &memoryStorageHandler{}, Logger: logger}
+	var relay = &Relay{logger: logger}
+	relay.SetStorage(stored)
+
+	assert.Error(t, relay.SetTransmitterFilters(7, structs.TransmitterFilters{TitleInclude: "closed"}))
+	assert.Empty(t, stored.GetTransmitters())
+	assert.Equal(t, structs.TransmitterFilters{}, relay.GetTransmitterFilters(7))
+}
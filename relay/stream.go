@@ -0,0 +1,249 @@
+package relay
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gorilla/websocket"
+)
+
+type StreamState string
+
+const (
+	StateStopped    StreamState = "stopped"
+	StateConnecting StreamState = "connecting"
+	StateConnected  StreamState = "connected"
+	StateBackingOff StreamState = "backing-off"
+)
+
+const (
+	streamPingPeriod   = 30 * time.Second
+	streamReadTimeout  = 75 * time.Second
+	streamWriteTimeout = 10 * time.Second
+	streamBaseDelay    = time.Second
+	streamMaxDelay     = time.Minute
+)
+
+// Snapshot of the stream supervisor.
+type StreamStatus struct {
+	State       StreamState
+	Since       time.Time
+	LastError   string
+	NextAttempt time.Time
+	Reconnects  int
+}
+
+// Human readable description of the status.
+func (status StreamStatus) String() string {
+	var description string
+	switch status.State {
+	case StateConnected:
+		description = "Connected since " + status.Since.Format("2006-01-02 15:04:05")
+	case StateBackingOff:
+		description = "Backing off. Next attempt at " + status.NextAttempt.Format("15:04:05")
+	case StateConnecting:
+		description = "Connecting"
+	default:
+		description = "Stopped"
+	}
+	if status.State != StateConnected && len(status.LastError) > 0 {
+		description += " (Last Error: " + status.LastError + ")"
+	}
+	return description + fmt.Sprintf(". Reconnects: %d", status.Reconnects)
+}
+
+// Starts the stream supervisor and the outbox. Both run until Stop is called.
+func (relay *Relay) Start() {
+	relay.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var done = make(chan struct{})
+	relay.cancel = cancel
+	relay.done = done
+	relay.reconnect = make(chan struct{}, 1)
+
+	go func() {
+		defer close(done)
+		var outbox sync.WaitGroup
+		outbox.Go(func() { relay.runOutbox(ctx) })
+		relay.supervise(ctx)
+		outbox.Wait()
+	}()
+}
+
+// Stops the stream supervisor and outbox. Blocks until the stream connection is closed and the outbox finished its
+// current pass, so a following Start never runs two outboxes delivering the same jobs.
+func (relay *Relay) Stop() error {
+	if relay.cancel != nil {
+		relay.cancel()
+		<-relay.done
+		relay.cancel = nil
+		relay.done = nil
+	}
+	relay.saveTransmitters()
+	return nil
+}
+
+func (relay *Relay) GetStreamStatus() StreamStatus {
+	relay.statusLock.Lock()
+	defer relay.statusLock.Unlock()
+	if len(relay.status.State) == 0 {
+		return StreamStatus{State: StateStopped}
+	}
+	return relay.status
+}
+
+func (relay *Relay) setState(state StreamState, err error, nextAttempt time.Time) {
+	relay.statusLock.Lock()
+	defer relay.statusLock.Unlock()
+	relay.status.State = state
+	relay.status.Since = time.Now()
+	relay.status.NextAttempt = nextAttempt
+	if err != nil {
+		relay.status.LastError = err.Error()
+	}
+}
+
+// Drops the current stream connection so it is reestablished straight away. Used after the token changes.
+func (relay *Relay) forceReconnect() {
+	relay.statusLock.Lock()
+	var listener = relay.listener
+	relay.statusLock.Unlock()
+	if listener != nil {
+		listener.Close()
+	}
+	select {
+	case relay.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+// Keeps a stream connection open until the context is cancelled. Reconnects with capped exponential backoff forever.
+func (relay *Relay) supervise(ctx context.Context) {
+	defer relay.setState(StateStopped, nil, time.Time{})
+	var failures = 0
+
+	for {
+		relay.setState(StateConnecting, nil, time.Time{})
+		connected, err := relay.runStream(ctx)
+		if ctx.Err() != nil {
+			relay.logger.Info("Stream connection closed", "user", relay.userName)
+			return
+		}
+
+		if connected {
+			failures = 0
+		}
+		failures++
+		var delay = streamBackoff(failures)
+		relay.logger.Warn("Stream disconnected. Reconnecting in "+delay.Round(time.Millisecond).String(), "user", relay.userName, "error", err)
+		relay.setState(StateBackingOff, err, time.Now().Add(delay))
+
+		var timer = time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-relay.reconnect:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		relay.statusLock.Lock()
+		relay.status.Reconnects++
+		relay.statusLock.Unlock()
+	}
+}
+
+// Connects to the stream and relays messages until the connection fails. Reports if a connection was made.
+func (relay *Relay) runStream(ctx context.Context) (bool, error) {
+	var server = relay.GetGotifyApi()
+	if _, err := server.GetServerInfo(); err != nil {
+		return false, err
+	}
+	con, err := server.GetStream()
+	if err != nil {
+		return false, err
+	}
+	defer con.Close()
+
+	relay.statusLock.Lock()
+	relay.listener = con
+	relay.statusLock.Unlock()
+	defer func() {
+		relay.statusLock.Lock()
+		relay.listener = nil
+		relay.statusLock.Unlock()
+	}()
+
+	stopClosing := context.AfterFunc(ctx, func() {
+		con.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(streamWriteTimeout))
+		con.Close()
+	})
+	defer stopClosing()
+
+	var extendDeadline = func() error {
+		return con.SetReadDeadline(time.Now().Add(streamReadTimeout))
+	}
+	extendDeadline()
+	con.SetPongHandler(func(string) error {
+		return extendDeadline()
+	})
+	con.SetPingHandler(func(data string) error {
+		extendDeadline()
+		return con.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(streamWriteTimeout))
+	})
+
+	var stopPinging = make(chan struct{})
+	defer close(stopPinging)
+	go func() {
+		var ticker = time.NewTicker(streamPingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopPinging:
+				return
+			case <-ticker.C:
+				if err := con.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	relay.setState(StateConnected, nil, time.Time{})
+	relay.logger.Info("Connected to stream", "user", relay.userName)
+	caughtUp, err := relay.backfill()
+	if err != nil {
+		// Live messages would move the last relayed ID past the missed ones. Reconnecting retries the whole gap instead.
+		return false, err
+	}
+
+	for {
+		extendDeadline()
+		var gotifyMessage = structs.GotifyMessageStruct{}
+		if err := con.ReadJSON(&gotifyMessage); err != nil {
+			return true, err
+		}
+		relay.countReceived(sourceStream)
+		if gotifyMessage.Id <= caughtUp {
+			// Posted while catching up so it was already relayed. Only compared within this connection as Gotify
+			// starts counting again after its database is reset.
+			continue
+		}
+		relay.relayMessage(gotifyMessage)
+	}
+}
+
+// Delay before reconnecting. Doubles with every failure up to streamMaxDelay with up to half of it randomised.
+func streamBackoff(failures int) time.Duration {
+	var delay = streamMaxDelay
+	if failures < 16 {
+		delay = min(streamBaseDelay<<(failures-1), streamMaxDelay)
+	}
+	return delay/2 + rand.N(delay/2)
+}
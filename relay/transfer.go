@@ -0,0 +1,199 @@
+package relay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/filters"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/CEKlopfenstein/gotify-repeater/transmitters"
+)
+
+// Layout version of ConfigExport. Raised whenever a change would stop older versions from importing it correctly.
+const ExportFormatVersion = 1
+
+// Transmitters, filters and settings of a relay. Used to copy them to another user or instance.
+type ConfigExport struct {
+	FormatVersion int
+	Exported      time.Time
+	// Set when the secrets were replaced by storage.RedactedSecret.
+	Redacted bool
+	// Relay the export was taken from. Redacted secrets are only filled in again when importing back into it.
+	RelayID      string            `json:",omitempty"`
+	Settings     *storage.Settings `json:",omitempty"`
+	Transmitters []ExportedTransmitter
+	// IDs of transmitters left out because their settings could not be read.
+	Unreadable []int `json:",omitempty"`
+}
+
+type ExportedTransmitter struct {
+	Id            int
+	Type          string
+	Active        bool
+	ConfigVersion int
+	// Settings of the transmitter with their secrets decrypted or redacted.
+	Config  json.RawMessage
+	Filters structs.TransmitterFilters
+}
+
+type ImportMode string
+
+const (
+	// Adds the imported transmitters next to the existing ones.
+	ImportMerge ImportMode = "merge"
+	// Removes every existing transmitter before adding the imported ones.
+	ImportReplace ImportMode = "replace"
+)
+
+// Changes made by an import, or that would be made in a dry run.
+type ImportResult struct {
+	Changes  []string
+	Warnings []string
+}
+
+// Collects the current transmitters, their filters and the relay settings. With redact secrets are left out.
+func (relay *Relay) Export(redact bool) (ConfigExport, error) {
+	var settings = relay.storage.GetSettings()
+	var export = ConfigExport{FormatVersion: ExportFormatVersion, Exported: time.Now(), Redacted: redact, RelayID: relay.storage.GetRelayID(), Settings: &settings, Transmitters: []ExportedTransmitter{}}
+
+	current, currentFilters := relay.snapshot()
+	for _, id := range slices.Sorted(maps.Keys(current)) {
+		var stored = current[id].GetStorageValue(id)
+		config, err := transmitters.ShowConfig(stored, redact)
+		if err != nil {
+			return ConfigExport{}, fmt.Errorf("failed to export transmitter %d: %w", id, err)
+		}
+		export.Transmitters = append(export.Transmitters, ExportedTransmitter{
+			Id:            id,
+			Type:          stored.TransmitterType,
+			Active:        stored.Active,
+			ConfigVersion: stored.ConfigVersion,
+			Config:        config,
+			Filters:       currentFilters[id].Settings(),
+		})
+	}
+
+	relay.lock.RLock()
+	export.Unreadable = slices.Sorted(maps.Keys(relay.unreadable))
+	relay.lock.RUnlock()
+	return export, nil
+}
+
+type importedTransmitter struct {
+	exported    ExportedTransmitter
+	stored      structs.TransmitterStorage
+	transmitter transmitters.Transmitter
+	filter      filters.Filter
+}
+
+// Adds the transmitters and settings of an export. Imported transmitters are given new IDs from
+// storage.GetCurrentTransmitterNextID onwards so they never collide with existing or previously used IDs.
+// When the export was taken from this relay, redacted secrets are taken from the existing transmitter with the same ID
+// and type. Exports from anywhere else never receive secrets of local transmitters. Secrets still missing are left
+// empty and their transmitters are imported disabled. Nothing is changed if any transmitter is invalid or when dryRun is set.
+func (relay *Relay) Import(export ConfigExport, mode ImportMode, dryRun bool) (ImportResult, error) {
+	var result = ImportResult{}
+	if export.FormatVersion == 0 {
+		return result, errors.New("not a Gotify Relay export")
+	}
+	if export.FormatVersion > ExportFormatVersion {
+		return result, fmt.Errorf("export format version %d is newer than the supported version %d. Update the plugin", export.FormatVersion, ExportFormatVersion)
+	}
+	if mode != ImportMerge && mode != ImportReplace {
+		return result, fmt.Errorf("unknown import mode %q", mode)
+	}
+
+	current, _ := relay.snapshot()
+	var sameRelay = len(export.RelayID) > 0 && export.RelayID == relay.storage.GetRelayID()
+	var imported = make([]importedTransmitter, 0, len(export.Transmitters))
+	for _, exported := range export.Transmitters {
+		var stored = structs.TransmitterStorage{TransmitterType: exported.Type, Active: exported.Active, Config: exported.Config, ConfigVersion: exported.ConfigVersion}
+		if len(stored.Config) > 0 {
+			var existing json.RawMessage
+			if transmitter := current[exported.Id]; transmitter != nil && sameRelay {
+				if existingStored := transmitter.GetStorageValue(exported.Id); existingStored.TransmitterType == exported.Type {
+					existing = existingStored.Config
+				}
+			}
+			config, missing, err := storage.FillRedactedSecrets(stored.Config, existing)
+			if err != nil {
+				return ImportResult{}, fmt.Errorf("transmitter %d: invalid config: %w", exported.Id, err)
+			}
+			stored.Config = config
+			if missing {
+				stored.Active = false
+				result.Warnings = append(result.Warnings, fmt.Sprintf("Transmitter %d (%s) is missing redacted secrets. They are left empty and it is imported disabled. Edit it before enabling it.", exported.Id, exported.Type))
+			}
+		}
+
+		transmitter, err := transmitters.RehydrateTransmitter(stored)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("transmitter %d: %w", exported.Id, err)
+		}
+		filter, err := filters.Build(exported.Filters)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("transmitter %d: invalid filters: %w", exported.Id, err)
+		}
+		imported = append(imported, importedTransmitter{exported: exported, stored: stored, transmitter: transmitter, filter: filter})
+	}
+
+	if mode == ImportReplace {
+		for _, id := range slices.Sorted(maps.Keys(current)) {
+			result.Changes = append(result.Changes, fmt.Sprintf("- Remove transmitter %d (%s)", id, current[id].GetStorageValue(id).TransmitterType))
+		}
+		relay.lock.RLock()
+		for _, id := range slices.Sorted(maps.Keys(relay.unreadable)) {
+			result.Changes = append(result.Changes, fmt.Sprintf("- Remove unreadable transmitter %d (%s)", id, relay.unreadable[id].TransmitterType))
+		}
+		relay.lock.RUnlock()
+	}
+
+	var nextID = relay.storage.GetCurrentTransmitterNextID()
+	for index := range imported {
+		var id = nextID + index
+		if !dryRun {
+			// Reserves the ID. Saved again with everything else once the transmitter is added to the relay below.
+			var reserved = imported[index].transmitter.GetStorageValue(id)
+			reserved.Filters = imported[index].exported.Filters
+			id = relay.storage.AddTransmitter(reserved)
+		}
+		imported[index].stored.Id = id
+		var status = "enabled"
+		if !imported[index].stored.Active {
+			status = "disabled"
+		}
+		result.Changes = append(result.Changes, fmt.Sprintf("+ Add transmitter %d (%s, %s) from exported transmitter %d", id, imported[index].exported.Type, status, imported[index].exported.Id))
+	}
+
+	var settings = relay.storage.GetSettings()
+	if export.Settings != nil && *export.Settings != settings {
+		result.Changes = append(result.Changes, fmt.Sprintf("~ Catch up on messages up to %d minutes old (was %d)", export.Settings.MaxBackfillMinutes, settings.MaxBackfillMinutes))
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	relay.update(func(current map[int]*lockedTransmitter, currentFilters map[int]filters.Filter) {
+		if mode == ImportReplace {
+			clear(current)
+			clear(currentFilters)
+			relay.unreadable = nil
+		}
+		for _, transmitter := range imported {
+			current[transmitter.stored.Id] = newLockedTransmitter(transmitter.transmitter)
+			currentFilters[transmitter.stored.Id] = transmitter.filter
+		}
+	})
+	relay.saveTransmitters()
+	if export.Settings != nil {
+		relay.storage.SaveSettings(*export.Settings)
+	}
+	relay.logger.Info(fmt.Sprintf("Imported %d transmitter(s) in %s mode", len(imported), mode))
+	return result, nil
+}
@@ -0,0 +1,81 @@
+package relay
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/CEKlopfenstein/gotify-repeater/transmitters"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Relay holding a single webhook transmitter with ID 1. No usable key so secrets are stored as plain text.
+func newTransferRelay(t *testing.T) *Relay {
+	t.Setenv(storage.SecretKeyEnv, "")
+	var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	var relay = &Relay{logger: logger}
+	relay.SetStorage(&storage.Storage{StorageHandler: &memoryStorageHandler{}, Logger: logger})
+	relay.storage.SaveSettings(storage.DefaultSettings())
+
+	webhook, err := transmitters.RehydrateTransmitter(structs.TransmitterStorage{
+		TransmitterType: "webhook",
+		Active:          true,
+		ConfigVersion:   1,
+		Config:          json.RawMessage(`{"URL":"https://example.org/hook/token","Method":"POST","Headers":{"Authorization":"Bearer abc"},"ContentType":"application/json","BodyTemplate":""}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 0, relay.AddTransmitter(webhook))
+	return relay
+}
+
+func TestExportRedactsUnencryptedSecrets(t *testing.T) {
+	var relay = newTransferRelay(t)
+
+	export, err := relay.Export(true)
+	assert.NoError(t, err)
+	assert.Len(t, export.Transmitters, 1)
+	assert.JSONEq(t, `{"URL":"REDACTED","Method":"POST","Headers":{"Authorization":"REDACTED"},"ContentType":"application/json","BodyTemplate":""}`, string(export.Transmitters[0].Config))
+
+	export, err = relay.Export(false)
+	assert.NoError(t, err)
+	assert.Contains(t, string(export.Transmitters[0].Config), "https://example.org/hook/token")
+}
+
+// Stored settings of a transmitter with its secrets decrypted.
+func importedConfig(t *testing.T, relay *Relay, id int) structs.TransmitterStorage {
+	var stored = relay.GetTransmitters()[id].GetStorageValue(id)
+	config, err := storage.RevealSecrets(stored.Config)
+	require.NoError(t, err)
+	stored.Config = config
+	return stored
+}
+
+func TestImportFillsSecretsOnlyIntoTheSameRelay(t *testing.T) {
+	var relay = newTransferRelay(t)
+	export, err := relay.Export(true)
+	require.NoError(t, err)
+	assert.NotEmpty(t, export.RelayID)
+
+	result, err := relay.Import(export, ImportMerge, false)
+	require.NoError(t, err)
+	assert.Empty(t, result.Warnings)
+	var imported = importedConfig(t, relay, 1)
+	assert.True(t, imported.Active)
+	assert.Contains(t, string(imported.Config), "https://example.org/hook/token")
+	assert.Contains(t, string(imported.Config), "Bearer abc")
+
+	// The same export taken from any other relay.
+	export.RelayID = "another relay"
+	result, err = relay.Import(export, ImportMerge, false)
+	require.NoError(t, err)
+	assert.Len(t, result.Warnings, 1)
+	imported = importedConfig(t, relay, 2)
+	assert.False(t, imported.Active)
+	assert.NotContains(t, string(imported.Config), "example.org/hook/token")
+	assert.NotContains(t, string(imported.Config), "Bearer abc")
+	assert.NotContains(t, string(imported.Config), storage.RedactedSecret)
+}
@@ -0,0 +1,496 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/CEKlopfenstein/gotify-repeater/config"
+)
+
+// Environment variable holding the keys secrets are encrypted with. Base64 encoded 32 byte keys separated by commas.
+// The first key encrypts. The others are only used to decrypt values stored before the key was changed.
+// When set the key file is ignored.
+const SecretKeyEnv = "GOTIFY_RELAY_SECRET_KEY"
+
+// Marks an encrypted value. Followed by the ID of the key and the base64 encoded nonce and ciphertext.
+const secretPrefix = "enc:v1:"
+
+// A value such as a token or webhook URL that is encrypted with AES-GCM whenever it is encoded as JSON.
+// Plain values stored before encryption existed are still read. They are encrypted the next time they are saved.
+type Secret string
+
+func (secret Secret) MarshalJSON() ([]byte, error) {
+	if len(secret) == 0 {
+		return json.Marshal("")
+	}
+	key, err := secretKeys.current()
+	if err != nil {
+		// Without a usable key secrets are stored as they were before encryption existed. Reported through GetSecretKeyStatus
+		// and logged by Storage.save.
+		secretKeys.unencryptedWrites.Add(1)
+		return json.Marshal(string(secret))
+	}
+	var nonce = make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	var sealed = key.aead.Seal(nonce, nonce, []byte(secret), []byte(key.id))
+	return json.Marshal(secretPrefix + key.id + ":" + base64.StdEncoding.EncodeToString(sealed))
+}
+
+func (secret *Secret) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(value, secretPrefix) {
+		*secret = Secret(value)
+		return nil
+	}
+
+	id, encoded, found := strings.Cut(strings.TrimPrefix(value, secretPrefix), ":")
+	if !found {
+		return errors.New("malformed encrypted secret")
+	}
+	key, err := secretKeys.find(id)
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return errors.New("malformed encrypted secret")
+	}
+	plain, err := key.aead.Open(nil, sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():], []byte(id))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt secret with key %s: %w", id, err)
+	}
+	*secret = Secret(plain)
+	return nil
+}
+
+type secretKey struct {
+	id   string
+	raw  []byte
+	aead cipher.AEAD
+}
+
+func newSecretKey(raw []byte) (secretKey, error) {
+	if len(raw) != 32 {
+		return secretKey{}, fmt.Errorf("secret keys must be 32 bytes not %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return secretKey{}, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return secretKey{}, err
+	}
+	var sum = sha256.Sum256(raw)
+	return secretKey{id: hex.EncodeToString(sum[:4]), raw: raw, aead: aead}, nil
+}
+
+// Keys shared by every user of the plugin. Read once on first use.
+type keyring struct {
+	lock   sync.Mutex
+	loaded bool
+	// Current key first.
+	keys []secretKey
+	// Where the keys were read from.
+	source string
+	err    error
+	// Secrets encoded without encryption as no key could be read.
+	unencryptedWrites atomic.Int64
+}
+
+var secretKeys keyring
+
+func (ring *keyring) load() {
+	if ring.loaded {
+		return
+	}
+	ring.loaded = true
+
+	if value, found := os.LookupEnv(SecretKeyEnv); found {
+		ring.source = "environment variable " + SecretKeyEnv
+		ring.keys, ring.err = parseSecretKeys(strings.Split(value, ","))
+		return
+	}
+
+	var path = config.SecretKeyFile()
+	ring.source = "key file " + path
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := generateSecretKey()
+		if err != nil {
+			ring.err = err
+			return
+		}
+		ring.err = writeSecretKeys(path, []secretKey{key})
+		if ring.err == nil {
+			ring.keys = []secretKey{key}
+		}
+		return
+	}
+	if err != nil {
+		ring.err = err
+		return
+	}
+	ring.keys, ring.err = parseSecretKeys(strings.Split(string(contents), "\n"))
+}
+
+func (ring *keyring) current() (secretKey, error) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	ring.load()
+	if ring.err != nil {
+		return secretKey{}, ring.err
+	}
+	return ring.keys[0], nil
+}
+
+func (ring *keyring) find(id string) (secretKey, error) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+	ring.load()
+	for _, key := range ring.keys {
+		if key.id == id {
+			return key, nil
+		}
+	}
+	if ring.err != nil {
+		return secretKey{}, fmt.Errorf("secret was encrypted with key %s but no keys could be read from the %s: %w", id, ring.source, ring.err)
+	}
+	return secretKey{}, fmt.Errorf("secret was encrypted with key %s which is not in the %s", id, ring.source)
+}
+
+// Reads base64 encoded keys. Blank lines and lines starting with # are skipped.
+func parseSecretKeys(lines []string) ([]secretKey, error) {
+	var keys []secretKey
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("secret key is not valid base64: %w", err)
+		}
+		key, err := newSecretKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no secret keys found")
+	}
+	return keys, nil
+}
+
+func generateSecretKey() (secretKey, error) {
+	var raw = make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return secretKey{}, err
+	}
+	return newSecretKey(raw)
+}
+
+// Replaces the key file. Written to a temporary file first so a failed write never loses the existing keys.
+func writeSecretKeys(path string, keys []secretKey) error {
+	var contents = "# Keys Gotify Relay encrypts stored secrets with. The first key encrypts, the others decrypt older values.\n# Losing this file makes every stored secret unreadable.\n"
+	for _, key := range keys {
+		contents += base64.StdEncoding.EncodeToString(key.raw) + "\n"
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), ".gotify-relay-key-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.WriteString(contents); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// Describes the keys secrets are encrypted with.
+type SecretKeyStatus struct {
+	// Where the keys are read from.
+	Source string
+	// ID of the key new values are encrypted with. Empty when secrets are stored unencrypted.
+	CurrentKey string
+	// Number of older keys kept to decrypt values stored before the key was rotated.
+	RetiredKeys int
+	// Why no key could be read. Secrets are stored unencrypted until it is fixed.
+	Error string
+	// Rotating is only possible when the keys come from the key file.
+	Rotatable bool
+	// Number of secrets saved unencrypted since Gotify started because no key could be read.
+	UnencryptedWrites int
+}
+
+func GetSecretKeyStatus() SecretKeyStatus {
+	secretKeys.lock.Lock()
+	defer secretKeys.lock.Unlock()
+	secretKeys.load()
+
+	var status = SecretKeyStatus{Source: secretKeys.source, UnencryptedWrites: int(secretKeys.unencryptedWrites.Load())}
+	_, fromEnv := os.LookupEnv(SecretKeyEnv)
+	status.Rotatable = !fromEnv
+	if secretKeys.err != nil {
+		status.Error = secretKeys.err.Error()
+		return status
+	}
+	status.CurrentKey = secretKeys.keys[0].id
+	status.RetiredKeys = len(secretKeys.keys) - 1
+	return status
+}
+
+// Generates a new key for encrypting secrets and writes it to the key file. Previous keys are kept to decrypt
+// values that have not been saved again yet, including those of other users. Returns the ID of the new key.
+func RotateSecretKey() (string, error) {
+	secretKeys.lock.Lock()
+	defer secretKeys.lock.Unlock()
+	secretKeys.load()
+
+	if _, fromEnv := os.LookupEnv(SecretKeyEnv); fromEnv {
+		return "", fmt.Errorf("the keys are set through %s. Add a new key to the start of it and restart Gotify instead", SecretKeyEnv)
+	}
+	var path = config.SecretKeyFile()
+	if secretKeys.err != nil {
+		// An unreadable key file may still hold keys existing secrets need. Never replace it.
+		if _, err := os.Stat(path); err == nil {
+			return "", fmt.Errorf("the existing key file could not be read so it is left as it is: %w", secretKeys.err)
+		}
+	}
+
+	key, err := generateSecretKey()
+	if err != nil {
+		return "", err
+	}
+	var keys = append([]secretKey{key}, secretKeys.keys...)
+	if err := writeSecretKeys(path, keys); err != nil {
+		return "", err
+	}
+	secretKeys.keys = keys
+	secretKeys.source = "key file " + path
+	secretKeys.err = nil
+	return key.id, nil
+}
+
+// Written in place of secrets when settings are exported without them.
+const RedactedSecret = "REDACTED"
+
+// Calls replace for every string within a decoded JSON value along with the path of keys leading to it.
+func replaceStrings(value any, path []string, replace func(path []string, value string) (string, error)) (any, error) {
+	switch typed := value.(type) {
+	case map[string]any:
+		for key, inner := range typed {
+			replaced, err := replaceStrings(inner, slices.Concat(path, []string{key}), replace)
+			if err != nil {
+				return nil, err
+			}
+			typed[key] = replaced
+		}
+	case []any:
+		for index, inner := range typed {
+			replaced, err := replaceStrings(inner, slices.Concat(path, []string{strconv.Itoa(index)}), replace)
+			if err != nil {
+				return nil, err
+			}
+			typed[index] = replaced
+		}
+	case string:
+		return replace(path, typed)
+	}
+	return value, nil
+}
+
+func decodeAny(data json.RawMessage) (any, error) {
+	var decoded any
+	var decoder = json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	err := decoder.Decode(&decoded)
+	return decoded, err
+}
+
+// Decrypts every encrypted secret within JSON encoded settings.
+// Secrets stored unencrypted can not be told apart from other values and are left as they are.
+func RevealSecrets(data json.RawMessage) (json.RawMessage, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	decoded, err := decodeAny(data)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err = replaceStrings(decoded, nil, func(path []string, value string) (string, error) {
+		if !strings.HasPrefix(value, secretPrefix) {
+			return value, nil
+		}
+		encoded, _ := json.Marshal(value)
+		var secret Secret
+		err := secret.UnmarshalJSON(encoded)
+		return string(secret), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
+
+// Replaces the secrets within JSON encoded settings with RedactedSecret. config is a value of the type the settings
+// were encoded from. Every Secret within that type is redacted whether it was stored encrypted or not, as is any
+// other encrypted value. Empty secrets are left empty.
+func RedactSecrets(data json.RawMessage, config any) (json.RawMessage, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	decoded, err := decodeAny(data)
+	if err != nil {
+		return nil, err
+	}
+	var paths [][]string
+	if config != nil {
+		paths = secretPaths(reflect.TypeOf(config), nil, nil)
+	}
+	decoded, err = replaceStrings(decoded, nil, func(path []string, value string) (string, error) {
+		if strings.HasPrefix(value, secretPrefix) {
+			return RedactedSecret, nil
+		}
+		if len(value) == 0 {
+			return value, nil
+		}
+		for _, secret := range paths {
+			if matchesPath(secret, path) {
+				return RedactedSecret, nil
+			}
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
+
+// Any map key or slice index within a path returned by secretPaths.
+const anyPathKey = "*"
+
+var secretType = reflect.TypeFor[Secret]()
+
+// Collects the paths of keys leading to every Secret within the JSON encoding of typ.
+func secretPaths(typ reflect.Type, path []string, paths [][]string) [][]string {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == secretType {
+		return append(paths, path)
+	}
+	switch typ.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return secretPaths(typ.Elem(), slices.Concat(path, []string{anyPathKey}), paths)
+	case reflect.Struct:
+		for index := range typ.NumField() {
+			var field = typ.Field(index)
+			if !field.IsExported() {
+				continue
+			}
+			var name, _, _ = strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if len(name) == 0 && field.Anonymous {
+				paths = secretPaths(field.Type, path, paths)
+				continue
+			}
+			if len(name) == 0 {
+				name = field.Name
+			}
+			paths = secretPaths(field.Type, slices.Concat(path, []string{name}), paths)
+		}
+	}
+	return paths
+}
+
+// Reports if path leads to the same place as pattern. Keys of structs are matched case insensitively like encoding/json does.
+func matchesPath(pattern []string, path []string) bool {
+	if len(pattern) != len(path) {
+		return false
+	}
+	for index, key := range pattern {
+		if key != anyPathKey && !strings.EqualFold(key, path[index]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replaces every RedactedSecret within JSON encoded settings with the value found at the same place within existing.
+// Secrets existing has no value for are left empty and reported.
+func FillRedactedSecrets(data json.RawMessage, existing json.RawMessage) (json.RawMessage, bool, error) {
+	decoded, err := decodeAny(data)
+	if err != nil {
+		return nil, false, err
+	}
+	var existingDecoded any
+	if len(existing) > 0 {
+		existingDecoded, err = decodeAny(existing)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	var missing = false
+	decoded, err = replaceStrings(decoded, nil, func(path []string, value string) (string, error) {
+		if value != RedactedSecret {
+			return value, nil
+		}
+		var found = existingDecoded
+		for _, key := range path {
+			switch typed := found.(type) {
+			case map[string]any:
+				found = typed[key]
+			case []any:
+				index, err := strconv.Atoi(key)
+				if err != nil || index >= len(typed) {
+					found = nil
+				} else {
+					found = typed[index]
+				}
+			default:
+				found = nil
+			}
+		}
+		if replacement, ok := found.(string); ok && replacement != RedactedSecret && len(replacement) > 0 {
+			return replacement, nil
+		}
+		missing = true
+		return "", nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	encoded, err := json.Marshal(decoded)
+	return encoded, missing, err
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	firstKey  = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	secondKey = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+)
+
+// Makes the keyring read its keys again. An empty value leaves the environment variable unset so the key file is used.
+func useSecretKeys(t *testing.T, value string) {
+	t.Setenv(SecretKeyEnv, value)
+	if len(value) == 0 {
+		os.Unsetenv(SecretKeyEnv)
+	}
+	secretKeys.lock.Lock()
+	defer secretKeys.lock.Unlock()
+	secretKeys.loaded = false
+	secretKeys.keys = nil
+	secretKeys.source = ""
+	secretKeys.err = nil
+}
+
+func encrypt(t *testing.T, secret Secret) string {
+	encoded, err := json.Marshal(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value string
+	json.Unmarshal(encoded, &value)
+	return value
+}
+
+func decrypt(value string) (Secret, error) {
+	encoded, _ := json.Marshal(value)
+	var secret Secret
+	err := json.Unmarshal(encoded, &secret)
+	return secret, err
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	useSecretKeys(t, firstKey)
+
+	var encrypted = encrypt(t, "https://discord.com/api/webhooks/1/token")
+	assert.True(t, strings.HasPrefix(encrypted, secretPrefix+GetSecretKeyStatus().CurrentKey+":"))
+	assert.NotContains(t, encrypted, "token")
+	assert.NotEqual(t, encrypted, encrypt(t, "https://discord.com/api/webhooks/1/token"), "every encryption uses a new nonce")
+
+	secret, err := decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, Secret("https://discord.com/api/webhooks/1/token"), secret)
+
+	// Values stored before encryption existed are read as they are.
+	secret, err = decrypt("plain-token")
+	assert.NoError(t, err)
+	assert.Equal(t, Secret("plain-token"), secret)
+	assert.Equal(t, "", encrypt(t, ""))
+}
+
+func TestSecretWrongKey(t *testing.T) {
+	useSecretKeys(t, firstKey)
+	var encrypted = encrypt(t, "token")
+
+	useSecretKeys(t, secondKey)
+	_, err := decrypt(encrypted)
+	assert.ErrorContains(t, err, "which is not in the environment variable")
+
+	// A retired key still decrypts.
+	useSecretKeys(t, secondKey+","+firstKey)
+	secret, err := decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, Secret("token"), secret)
+
+	// Changing the ciphertext is detected.
+	var tampered = []byte(encrypted)
+	tampered[len(tampered)-2] ^= 1
+	_, err = decrypt(string(tampered))
+	assert.Error(t, err)
+	_, err = decrypt(secretPrefix + "nokey")
+	assert.ErrorContains(t, err, "malformed")
+}
+
+func TestSecretWithoutKey(t *testing.T) {
+	useSecretKeys(t, "not base64!")
+	var before = GetSecretKeyStatus().UnencryptedWrites
+
+	assert.Equal(t, "token", encrypt(t, "token"))
+	var status = GetSecretKeyStatus()
+	assert.NotEmpty(t, status.Error)
+	assert.Empty(t, status.CurrentKey)
+	assert.Equal(t, before+1, status.UnencryptedWrites)
+}
+
+func TestRotateSecretKey(t *testing.T) {
+	var dir = t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("GOTIFY_PLUGINSDIR", filepath.Join(dir, "plugins"))
+	useSecretKeys(t, "")
+
+	// The key file is generated on first use.
+	var encrypted = encrypt(t, "token")
+	var original = GetSecretKeyStatus()
+	assert.True(t, original.Rotatable)
+	assert.FileExists(t, filepath.Join(dir, "gotify-relay.key"))
+
+	rotated, err := RotateSecretKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, original.CurrentKey, rotated)
+	assert.True(t, strings.HasPrefix(encrypt(t, "token"), secretPrefix+rotated+":"))
+
+	// Read back from the key file with the old key retired.
+	useSecretKeys(t, "")
+	var status = GetSecretKeyStatus()
+	assert.Equal(t, rotated, status.CurrentKey)
+	assert.Equal(t, 1, status.RetiredKeys)
+	secret, err := decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, Secret("token"), secret)
+
+	useSecretKeys(t, firstKey)
+	_, err = RotateSecretKey()
+	assert.ErrorContains(t, err, SecretKeyEnv)
+}
+
+func TestRevealSecrets(t *testing.T) {
+	useSecretKeys(t, firstKey)
+	config, _ := json.Marshal(map[string]any{"URL": Secret("https://example.org/hook"), "Method": "POST", "Headers": map[string]Secret{"Authorization": "Bearer abc"}})
+
+	revealed, err := RevealSecrets(config)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"URL":"https://example.org/hook","Method":"POST","Headers":{"Authorization":"Bearer abc"}}`, string(revealed))
+
+	redacted, err := RedactSecrets(config, nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"URL":"REDACTED","Method":"POST","Headers":{"Authorization":"REDACTED"}}`, string(redacted))
+}
+
+type redactedConfig struct {
+	URL     Secret
+	Method  string
+	Headers map[string]Secret
+	Tokens  []Secret `json:"tokens"`
+	Empty   Secret
+	Nested  struct{ Password *Secret }
+}
+
+func TestRedactSecretsWithoutKey(t *testing.T) {
+	useSecretKeys(t, "")
+	var password = Secret("hunter2")
+	config, _ := json.Marshal(redactedConfig{
+		URL:     "https://example.org/hook",
+		Method:  "POST",
+		Headers: map[string]Secret{"Authorization": "Bearer abc"},
+		Tokens:  []Secret{"first", "second"},
+		Nested:  struct{ Password *Secret }{Password: &password},
+	})
+
+	redacted, err := RedactSecrets(config, redactedConfig{})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"URL":"REDACTED","Method":"POST","Headers":{"Authorization":"REDACTED"},"tokens":["REDACTED","REDACTED"],"Empty":"","Nested":{"Password":"REDACTED"}}`, string(redacted))
+}
+
+func TestFillRedactedSecrets(t *testing.T) {
+	var existing = json.RawMessage(`{"URL":"https://example.org/hook","Headers":{"Authorization":"Bearer abc"},"List":["a","b"]}`)
+
+	filled, missing, err := FillRedactedSecrets(json.RawMessage(`{"URL":"REDACTED","Headers":{"Authorization":"REDACTED"},"List":["x","REDACTED"],"Method":"PUT"}`), existing)
+	assert.NoError(t, err)
+	assert.False(t, missing)
+	assert.JSONEq(t, `{"URL":"https://example.org/hook","Headers":{"Authorization":"Bearer abc"},"List":["x","b"],"Method":"PUT"}`, string(filled))
+
+	filled, missing, err = FillRedactedSecrets(json.RawMessage(`{"Token":"REDACTED"}`), existing)
+	assert.NoError(t, err)
+	assert.True(t, missing)
+	assert.JSONEq(t, `{"Token":""}`, string(filled))
+}
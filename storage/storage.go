@@ -1,8 +1,15 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/CEKlopfenstein/gotify-repeater/structs"
 	"github.com/gotify/plugin-api"
@@ -10,15 +17,62 @@ import (
 
 type Storage struct {
 	StorageHandler plugin.StorageHandler
-	Logger         *log.Logger
+	Logger         *slog.Logger
 	innerStore     innerStorageStruct
+	lock           sync.Mutex
+	// Set while the stored data can not be read. Nothing is saved until it is cleared by a successful load.
+	loadError error
+	// Set once it was logged that secrets were saved unencrypted.
+	warnedUnencrypted bool
 }
 
 type innerStorageStruct struct {
+	// Layout version of the stored data. See migrations.
+	SchemaVersion int
+	// Copy of the stored data from before the last migration.
+	Backup       *Backup `json:",omitempty"`
 	Contact      Contact
-	ClientToken  string
+	ClientToken  Secret
 	Transmitters map[int]structs.TransmitterStorage
 	NextID       int
+	Outbox       []structs.OutboxJob
+	DeadLetters  []structs.OutboxJob
+	NextJobID    int
+	// ID of the newest message relayed. Used to catch up on messages missed while disconnected.
+	LastMessageId int
+	Settings      *Settings
+	// Random ID of this relay. Exports carry it so an import can tell if it comes back into the relay it was taken from.
+	RelayID string `json:",omitempty"`
+}
+
+type Settings struct {
+	// Messages older than this are not relayed when catching up after a disconnect. 0 disables catching up.
+	MaxBackfillMinutes int
+}
+
+func DefaultSettings() Settings {
+	return Settings{MaxBackfillMinutes: 60}
+}
+
+type Backup struct {
+	SchemaVersion int
+	Created       time.Time
+	Data          json.RawMessage
+}
+
+// Upgrades stored data by one schema version. Works on the raw top level fields as older layouts may not fit innerStorageStruct.
+type migration func(data map[string]json.RawMessage) error
+
+// Ordered list of migrations. migrations[i] upgrades the stored data from schema version i to i+1.
+// Append new migrations to the end. Never change or remove existing ones.
+var migrations = []migration{
+	// 0 to 1: Data stored before schema versioning. The layout is unchanged.
+	func(data map[string]json.RawMessage) error { return nil },
+}
+
+// Schema version written by this build.
+func currentSchemaVersion() int {
+	return len(migrations)
 }
 
 type Contact struct {
@@ -28,63 +82,189 @@ type Contact struct {
 }
 
 // Saves the current inner storage struct. Should be called after every save/set
+// Refuses to save while the stored data can not be read so it is never overwritten.
 func (storage *Storage) save() {
-	storageBytes, _ := json.Marshal(storage.innerStore)
+	if storage.loadError != nil {
+		storage.Logger.Error("REFUSING TO SAVE: The stored data of the relay could not be read and would be overwritten", "error", storage.loadError)
+		return
+	}
+	storage.innerStore.SchemaVersion = currentSchemaVersion()
+	var unencrypted = secretKeys.unencryptedWrites.Load()
+	storageBytes, err := json.Marshal(storage.innerStore)
+	if err != nil {
+		storage.Logger.Error("Failed to encode stored data. Nothing was saved", "error", err)
+		return
+	}
+	if secretKeys.unencryptedWrites.Load() > unencrypted && !storage.warnedUnencrypted {
+		storage.warnedUnencrypted = true
+		storage.Logger.Warn("Secrets were saved unencrypted because no secret key could be read. See the secret key status on the config page", "error", GetSecretKeyStatus().Error)
+	}
 	storage.StorageHandler.Save(storageBytes)
 }
 
 // Loads the stored values from the DB into the current inner storage struct. Should be called before every get.
+// Stored data from older schema versions is migrated and saved again.
 func (storage *Storage) load() {
 	storageBytes, err := storage.StorageHandler.Load()
 	if err != nil {
-		storage.Logger.Println(err)
+		storage.Logger.Error(err.Error())
+		storage.loadError = err
 		return
 	}
 
 	if len(storageBytes) == 0 {
-		storageBytes, _ = json.Marshal(storage.innerStore)
-		storage.StorageHandler.Save(storageBytes)
-	} else {
-		json.Unmarshal(storageBytes, &storage.innerStore)
+		storage.loadError = nil
+		if storage.innerStore.Transmitters == nil {
+			storage.innerStore.Transmitters = make(map[int]structs.TransmitterStorage)
+		}
+		storage.save()
+		return
+	}
+
+	storageBytes, backup, err := migrate(storageBytes)
+	if err == nil {
+		var loaded innerStorageStruct
+		err = json.Unmarshal(storageBytes, &loaded)
+		if err == nil && backup != nil {
+			// Saved before the migrated data replaces it.
+			err = storage.StorageHandler.Save(backup)
+			if err != nil {
+				err = fmt.Errorf("failed to save backup before migrating: %w", err)
+			}
+		}
+		if err == nil {
+			storage.innerStore = loaded
+		}
+	}
+	if err != nil {
+		if storage.loadError == nil || storage.loadError.Error() != err.Error() {
+			storage.Logger.Error("FAILED TO READ STORED DATA. Changes will not be saved until this is fixed", "error", err)
+		}
+		storage.loadError = err
+		return
 	}
+	storage.loadError = nil
 
 	if storage.innerStore.Transmitters == nil {
 		storage.innerStore.Transmitters = make(map[int]structs.TransmitterStorage)
 	}
+	if backup != nil {
+		storage.Logger.Info(fmt.Sprintf("Migrated stored data to schema version %d. The previous data is kept as a backup", currentSchemaVersion()))
+		storage.save()
+	}
+}
+
+// Runs every migration needed to bring the stored data up to the current schema version.
+// Also returns the stored data with a backup of itself added, to be saved before the migrated data. Nil if nothing was migrated.
+func migrate(storageBytes []byte) ([]byte, []byte, error) {
+	var data map[string]json.RawMessage
+	err := json.Unmarshal(storageBytes, &data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("stored data is not valid JSON: %w", err)
+	}
+
+	var version = 0
+	if rawVersion, found := data["SchemaVersion"]; found {
+		err = json.Unmarshal(rawVersion, &version)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid schema version: %w", err)
+		}
+	}
+	if version > currentSchemaVersion() {
+		return nil, nil, fmt.Errorf("stored data has schema version %d which is newer than the supported version %d. Update the plugin", version, currentSchemaVersion())
+	}
+	if version == currentSchemaVersion() {
+		return storageBytes, nil, nil
+	}
+
+	// The previous backup is left out so backups do not nest.
+	var previous = maps.Clone(data)
+	delete(previous, "Backup")
+	previousBytes, err := json.Marshal(previous)
+	if err != nil {
+		return nil, nil, err
+	}
+	backupBytes, err := json.Marshal(Backup{SchemaVersion: version, Created: time.Now(), Data: previousBytes})
+	if err != nil {
+		return nil, nil, err
+	}
+	data["Backup"] = backupBytes
+	withBackup, err := json.Marshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for ; version < currentSchemaVersion(); version++ {
+		err = migrations[version](data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("migration from schema version %d failed: %w", version, err)
+		}
+	}
+
+	migratedBytes, err := json.Marshal(data)
+	return migratedBytes, withBackup, err
+}
+
+// Reports why the stored data could not be read. Nil if it was read successfully.
+func (storage *Storage) LoadError() error {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	return storage.loadError
 }
 
 func (storage *Storage) GetContact() Contact {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
 	storage.load()
 	return storage.innerStore.Contact
 }
 
 func (storage *Storage) SaveContact(contact Contact) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
 	storage.innerStore.Contact = contact
 	storage.save()
 }
 
 func (storage *Storage) GetClientToken() string {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
 	storage.load()
-	return storage.innerStore.ClientToken
+	return string(storage.innerStore.ClientToken)
 }
 
 func (storage *Storage) SaveClientToken(token string) {
-	storage.innerStore.ClientToken = token
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	storage.innerStore.ClientToken = Secret(token)
 	storage.save()
 }
 
 func (storage *Storage) GetTransmitters() map[int]structs.TransmitterStorage {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
 	storage.load()
-	return storage.innerStore.Transmitters
+	// Copied so callers never share the map that load decodes into.
+	return maps.Clone(storage.innerStore.Transmitters)
 }
 
 func (storage *Storage) SaveTransmitters(transmitters map[int]structs.TransmitterStorage) {
-	storage.innerStore.Transmitters = transmitters
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	storage.innerStore.Transmitters = maps.Clone(transmitters)
 	storage.save()
 }
 
 func (storage *Storage) AddTransmitter(transmitter structs.TransmitterStorage) int {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
 	var id = storage.innerStore.NextID
+	transmitter.Id = id
 	storage.innerStore.Transmitters[id] = transmitter
 	storage.innerStore.NextID++
 	storage.save()
@@ -92,5 +272,148 @@ func (storage *Storage) AddTransmitter(transmitter structs.TransmitterStorage) i
 }
 
 func (storage *Storage) GetCurrentTransmitterNextID() int {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
 	return storage.innerStore.NextID
 }
+
+func (storage *Storage) GetOutbox() []structs.OutboxJob {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	return slices.Clone(storage.innerStore.Outbox)
+}
+
+// Adds a job to the outbox and returns the ID assigned to it.
+func (storage *Storage) AddOutboxJob(job structs.OutboxJob) int {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	job.Id = storage.innerStore.NextJobID
+	storage.innerStore.NextJobID++
+	storage.innerStore.Outbox = append(storage.innerStore.Outbox, job)
+	storage.save()
+	return job.Id
+}
+
+// Replaces the outbox job with the same ID. Jobs no longer in the outbox are ignored.
+func (storage *Storage) UpdateOutboxJob(job structs.OutboxJob) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	var index = slices.IndexFunc(storage.innerStore.Outbox, func(stored structs.OutboxJob) bool { return stored.Id == job.Id })
+	if index == -1 {
+		return
+	}
+	storage.innerStore.Outbox[index] = job
+	storage.save()
+}
+
+func (storage *Storage) RemoveOutboxJob(id int) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	storage.innerStore.Outbox = slices.DeleteFunc(storage.innerStore.Outbox, func(stored structs.OutboxJob) bool { return stored.Id == id })
+	storage.save()
+}
+
+// Moves the job out of the outbox and onto the dead letter list.
+func (storage *Storage) DeadLetterOutboxJob(job structs.OutboxJob) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	storage.innerStore.Outbox = slices.DeleteFunc(storage.innerStore.Outbox, func(stored structs.OutboxJob) bool { return stored.Id == job.Id })
+	storage.innerStore.DeadLetters = append(storage.innerStore.DeadLetters, job)
+	storage.save()
+}
+
+func (storage *Storage) GetDeadLetters() []structs.OutboxJob {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	return slices.Clone(storage.innerStore.DeadLetters)
+}
+
+func (storage *Storage) UpdateDeadLetter(job structs.OutboxJob) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	var index = slices.IndexFunc(storage.innerStore.DeadLetters, func(stored structs.OutboxJob) bool { return stored.Id == job.Id })
+	if index == -1 {
+		return
+	}
+	storage.innerStore.DeadLetters[index] = job
+	storage.save()
+}
+
+// Removes every dead letter the given function returns true for. Returns the number removed.
+func (storage *Storage) RemoveDeadLetters(remove func(job structs.OutboxJob) bool) int {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	var count = len(storage.innerStore.DeadLetters)
+	storage.innerStore.DeadLetters = slices.DeleteFunc(storage.innerStore.DeadLetters, remove)
+	storage.save()
+	return count - len(storage.innerStore.DeadLetters)
+}
+
+func (storage *Storage) GetLastMessageId() int {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	return storage.innerStore.LastMessageId
+}
+
+// Records the ID of a relayed message. IDs lower than the current value are ignored.
+func (storage *Storage) SaveLastMessageId(id int) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	if id <= storage.innerStore.LastMessageId {
+		return
+	}
+	storage.innerStore.LastMessageId = id
+	storage.save()
+}
+
+// Replaces the ID of the last relayed message even if it is lower. Used when the Gotify database was reset.
+func (storage *Storage) ResetLastMessageId(id int) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	storage.innerStore.LastMessageId = id
+	storage.save()
+}
+
+// Returns the random ID of this relay. Created the first time it is asked for.
+func (storage *Storage) GetRelayID() string {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	if len(storage.innerStore.RelayID) == 0 {
+		var raw = make([]byte, 16)
+		rand.Read(raw)
+		storage.innerStore.RelayID = hex.EncodeToString(raw)
+		storage.save()
+	}
+	return storage.innerStore.RelayID
+}
+
+func (storage *Storage) GetSettings() Settings {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	if storage.innerStore.Settings == nil {
+		return DefaultSettings()
+	}
+	return *storage.innerStore.Settings
+}
+
+func (storage *Storage) SaveSettings(settings Settings) {
+	storage.lock.Lock()
+	defer storage.lock.Unlock()
+	storage.load()
+	storage.innerStore.Settings = &settings
+	storage.save()
+}
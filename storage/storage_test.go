@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Storage handler keeping every save. Load returns the last one unless loadErr is set.
+type fakeStorageHandler struct {
+	saves   [][]byte
+	loadErr error
+	saveErr error
+}
+
+func (handler *fakeStorageHandler) Save(data []byte) error {
+	if handler.saveErr != nil {
+		return handler.saveErr
+	}
+	handler.saves = append(handler.saves, data)
+	return nil
+}
+
+func (handler *fakeStorageHandler) Load() ([]byte, error) {
+	if handler.loadErr != nil {
+		return nil, handler.loadErr
+	}
+	if len(handler.saves) == 0 {
+		return nil, nil
+	}
+	return handler.saves[len(handler.saves)-1], nil
+}
+
+func newFakeStorage(stored string) (*Storage, *fakeStorageHandler) {
+	var handler = &fakeStorageHandler{}
+	if len(stored) > 0 {
+		handler.saves = [][]byte{[]byte(stored)}
+	}
+	return &Storage{StorageHandler: handler, Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}, handler
+}
+
+func decodeSaved(t *testing.T, data []byte) map[string]json.RawMessage {
+	var decoded map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	return decoded
+}
+
+func TestLoadMigratesUnversionedData(t *testing.T) {
+	var original = `{"Contact":{"FirstName":"Ada","LastName":"","Email":""},"NextID":3,"LastMessageId":42}`
+	storage, handler := newFakeStorage(original)
+
+	assert.Equal(t, "Ada", storage.GetContact().FirstName)
+	assert.NoError(t, storage.LoadError())
+	// The original, the backup and the migrated data.
+	require.Len(t, handler.saves, 3)
+
+	var backupSave = decodeSaved(t, handler.saves[1])
+	var backup Backup
+	require.NoError(t, json.Unmarshal(backupSave["Backup"], &backup))
+	assert.Equal(t, 0, backup.SchemaVersion)
+	assert.JSONEq(t, original, string(backup.Data))
+	assert.NotContains(t, backupSave, "SchemaVersion", "the backup is saved before the data is migrated")
+
+	var migrated = decodeSaved(t, handler.saves[2])
+	assert.JSONEq(t, "1", string(migrated["SchemaVersion"]))
+	assert.JSONEq(t, "42", string(migrated["LastMessageId"]))
+	assert.Contains(t, migrated, "Backup")
+}
+
+func TestLoadRunsMigrationsInOrder(t *testing.T) {
+	var previous = migrations
+	t.Cleanup(func() { migrations = previous })
+	migrations = append(slices.Clone(migrations), func(data map[string]json.RawMessage) error {
+		// Renames a field as a future migration might.
+		data["LastMessageId"] = data["LastSeen"]
+		delete(data, "LastSeen")
+		return nil
+	})
+	storage, handler := newFakeStorage(`{"SchemaVersion":1,"LastSeen":7}`)
+
+	assert.Equal(t, 7, storage.GetLastMessageId())
+	var migrated = decodeSaved(t, handler.saves[len(handler.saves)-1])
+	assert.JSONEq(t, "2", string(migrated["SchemaVersion"]))
+	assert.NotContains(t, migrated, "LastSeen")
+
+	var backup Backup
+	require.NoError(t, json.Unmarshal(migrated["Backup"], &backup))
+	assert.Equal(t, 1, backup.SchemaVersion)
+	assert.JSONEq(t, `{"SchemaVersion":1,"LastSeen":7}`, string(backup.Data))
+}
+
+func TestLoadKeepsCurrentSchema(t *testing.T) {
+	storage, handler := newFakeStorage(`{"SchemaVersion":1,"NextID":5}`)
+
+	assert.Equal(t, 5, storage.GetCurrentTransmitterNextID())
+	assert.Len(t, handler.saves, 1, "data of the current schema version is not saved again")
+}
+
+func TestLoadRejectsNewerSchema(t *testing.T) {
+	storage, handler := newFakeStorage(`{"SchemaVersion":99,"NextID":5}`)
+
+	assert.ErrorContains(t, storage.LoadError(), "newer than the supported version")
+	storage.SaveContact(Contact{FirstName: "Ada"})
+	assert.Len(t, handler.saves, 1)
+	assert.JSONEq(t, `{"SchemaVersion":99,"NextID":5}`, string(handler.saves[0]))
+}
+
+func TestLoadRejectsInvalidJSON(t *testing.T) {
+	storage, handler := newFakeStorage(`{"NextID":`)
+
+	assert.ErrorContains(t, storage.LoadError(), "not valid JSON")
+	storage.SaveLastMessageId(10)
+	assert.Len(t, handler.saves, 1)
+}
+
+func TestNoSaveAfterLoadError(t *testing.T) {
+	storage, handler := newFakeStorage(`{"SchemaVersion":1,"NextID":5}`)
+	handler.loadErr = errors.New("database locked")
+
+	assert.ErrorContains(t, storage.LoadError(), "database locked")
+	storage.SaveContact(Contact{FirstName: "Ada"})
+	assert.Len(t, handler.saves, 1)
+
+	// Saving resumes once the data can be read again.
+	handler.loadErr = nil
+	storage.SaveContact(Contact{FirstName: "Ada"})
+	require.Len(t, handler.saves, 2)
+	assert.JSONEq(t, "5", string(decodeSaved(t, handler.saves[1])["NextID"]))
+}
+
+func TestFailedBackupStopsMigration(t *testing.T) {
+	storage, handler := newFakeStorage(`{"NextID":5}`)
+	handler.saveErr = errors.New("disk full")
+
+	assert.ErrorContains(t, storage.LoadError(), "failed to save backup before migrating")
+	assert.Equal(t, 0, storage.GetCurrentTransmitterNextID())
+	assert.Len(t, handler.saves, 1)
+}
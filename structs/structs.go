@@ -1,5 +1,14 @@
 package structs
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
 // Contains Structs that I need to be able to have intialized in other packages.
 // Without causing circular dependancies.
 
@@ -16,12 +25,155 @@ type GotifyMessageStruct struct {
 	Message  string
 	Title    string
 	Priority int
+	Extras   map[string]any
 }
 
 type TransmitterStorage struct {
 	Id              int
 	Active          bool
 	TransmitterType string
-	URLorTOKEN      string
-	TransmitCount   int
+	// Deprecated: Settings are kept in Config. Only read to migrate transmitters stored before Config existed.
+	URLorTOKEN string `json:",omitempty"`
+	// Settings of the transmitter. Encoded from the config struct owned by the transmitter package.
+	Config json.RawMessage `json:",omitempty"`
+	// Layout version of Config. 0 means the settings are still kept in URLorTOKEN.
+	ConfigVersion int `json:",omitempty"`
+	TransmitCount int
+	Filters       TransmitterFilters
+}
+
+// Decodes the settings of a stored transmitter into the config struct of its package.
+// Transmitters stored before Config existed are converted from URLorTOKEN by legacy.
+func DecodeConfig[C any](stored TransmitterStorage, legacy func(urlOrToken string) (C, error)) (C, error) {
+	var config C
+	if stored.ConfigVersion == 0 && len(stored.Config) == 0 {
+		if legacy == nil {
+			return config, nil
+		}
+		return legacy(stored.URLorTOKEN)
+	}
+	err := json.Unmarshal(stored.Config, &config)
+	return config, err
+}
+
+// Stores the settings of a transmitter along with the layout version of its config struct.
+func (stored *TransmitterStorage) SetConfig(config any, version int) {
+	stored.Config, _ = json.Marshal(config)
+	stored.ConfigVersion = version
+	stored.URLorTOKEN = ""
+}
+
+// Routing rules checked before a message is handed to a transmitter.
+// Empty values do not restrict anything.
+type TransmitterFilters struct {
+	AllowedApps    []int
+	DeniedApps     []int
+	MinPriority    *int
+	MaxPriority    *int
+	TitleInclude   string
+	TitleExclude   string
+	MessageInclude string
+	MessageExclude string
+}
+
+// A delivery that failed and is waiting to be retried. Once it runs out of attempts it is kept as a dead letter.
+type OutboxJob struct {
+	Id            int
+	TransmitterId int
+	Message       GotifyMessageStruct
+	Attempts      int
+	LastError     string
+	Created       time.Time
+	LastAttempt   time.Time
+	NextAttempt   time.Time
+}
+
+// Returned by a transmitter when the destination asked for deliveries to be paused.
+type RetryAfterError struct {
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (err *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", err.Err.Error(), err.RetryAfter)
+}
+
+func (err *RetryAfterError) Unwrap() error {
+	return err.Err
+}
+
+// Returned by a transmitter that deliberately did not send the message. The delivery is neither counted nor retried.
+var ErrSkipped = errors.New("message skipped by transmitter")
+
+// Drops the request URL from errors of net/http. Webhook URLs carry their token and must not end up in
+// the outbox, dead letters or logs.
+func WithoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// Reports if raw is an absolute http or https URL with a host.
+func IsHTTPURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && len(parsed.Host) > 0
+}
+
+// Collects the status a destination responded with. See WithResponseRecorder.
+type ResponseRecorder struct {
+	Status string
+}
+
+type responseRecorderKey struct{}
+
+// Returns a context that collects the response status transmitters report through RecordResponse.
+func WithResponseRecorder(ctx context.Context) (context.Context, *ResponseRecorder) {
+	var recorder = &ResponseRecorder{}
+	return context.WithValue(ctx, responseRecorderKey{}, recorder), recorder
+}
+
+// Reports the status the destination responded with. Does nothing unless the context came from WithResponseRecorder.
+func RecordResponse(ctx context.Context, status string) {
+	if recorder, ok := ctx.Value(responseRecorderKey{}).(*ResponseRecorder); ok {
+		recorder.Status = status
+	}
+}
+
+// Looks up a nested value within the message extras. Returns nil if any part of the path is missing.
+func (msg GotifyMessageStruct) ExtrasValue(path ...string) any {
+	var current any = msg.Extras
+	for _, key := range path {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = object[key]
+	}
+	return current
+}
+
+func (msg GotifyMessageStruct) extrasString(path ...string) string {
+	value, _ := msg.ExtrasValue(path...).(string)
+	return value
+}
+
+// The client::display contentType of the message. Empty when not set. (Gotify treats that as text/plain)
+func (msg GotifyMessageStruct) ContentType() string {
+	return msg.extrasString("client::display", "contentType")
+}
+
+func (msg GotifyMessageStruct) IsMarkdown() bool {
+	return msg.ContentType() == "text/markdown"
+}
+
+// URL to open when the notification is clicked. Empty when not set.
+func (msg GotifyMessageStruct) ClickURL() string {
+	return msg.extrasString("client::notification", "click", "url")
+}
+
+// Image to display alongside the notification. Empty when not set.
+func (msg GotifyMessageStruct) BigImageURL() string {
+	return msg.extrasString("client::notification", "bigImageUrl")
 }
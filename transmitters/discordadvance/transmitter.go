@@ -2,39 +2,62 @@ package discordadvanceTransmitter
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
 	"github.com/CEKlopfenstein/gotify-repeater/structs"
 	"github.com/gin-gonic/gin"
 )
 
 type DiscordAdvanceTransmitter struct {
 	username      string
-	discord       string
+	config        DiscordConfig
 	status        bool
 	transmitCount int
 }
 
+// Layout version of DiscordConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for a Discord transmitter.
+type DiscordConfig struct {
+	WebhookURL storage.Secret
+	// Replaces the avatar configured for the webhook. Optional.
+	AvatarURL string
+	// Posts into a thread of the webhook's channel. Optional.
+	ThreadID string
+}
+
 type DiscordWebhookPayload struct {
-	Username string                  `json:"username"`
-	Embeds   []DiscordEmbedStructure `json:"embeds"`
+	Username  string                  `json:"username"`
+	AvatarURL string                  `json:"avatar_url,omitempty"`
+	Embeds    []DiscordEmbedStructure `json:"embeds"`
 }
 
 type DiscordEmbedStructure struct {
 	Title       string              `json:"title"`
 	Type        string              `json:"type"`
 	Description string              `json:"description"`
-	Url         string              `json:"url"`
+	Url         string              `json:"url,omitempty"`
 	Timestamp   string              `json:"timestamp"`
 	Color       int                 `json:"color"`
 	Fields      []DiscordEmbedField `json:"fields"`
+	Image       *DiscordEmbedImage  `json:"image,omitempty"`
+}
+
+type DiscordEmbedImage struct {
+	Url string `json:"url"`
 }
 
 type DiscordEmbedField struct {
@@ -47,8 +70,8 @@ type DiscordHookInfo struct {
 	Name string
 }
 
-func Build(discordHook string, name string, status bool, count int) DiscordAdvanceTransmitter {
-	var transmitter = DiscordAdvanceTransmitter{discord: discordHook}
+func Build(config DiscordConfig, name string, status bool, count int) DiscordAdvanceTransmitter {
+	var transmitter = DiscordAdvanceTransmitter{config: config}
 
 	var hookInfo, err = transmitter.getHookInfo()
 	if err != nil {
@@ -64,12 +87,55 @@ func Build(discordHook string, name string, status bool, count int) DiscordAdvan
 	return transmitter
 }
 
+// Transmitters stored before typed configs existed only kept the webhook URL.
+func migrateLegacyConfig(urlOrToken string) (DiscordConfig, error) {
+	return DiscordConfig{WebhookURL: storage.Secret(urlOrToken)}, nil
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (DiscordConfig, error) {
+	return structs.DecodeConfig(stored, migrateLegacyConfig)
+}
+
+// Only checks the settings themselves. The webhook is looked up with Discord separately.
+func validateConfig(config DiscordConfig) error {
+	if !structs.IsHTTPURL(string(config.WebhookURL)) {
+		return errors.New("the webhook URL must start with http:// or https://")
+	}
+	return nil
+}
+
+// Checks stored settings without contacting Discord.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*DiscordAdvanceTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, fmt.Sprintf("Transmitter %d", stored.Id), stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+func configFromForm(ctx *gin.Context) DiscordConfig {
+	return DiscordConfig{
+		WebhookURL: storage.Secret(ctx.PostForm("discord-url")),
+		AvatarURL:  ctx.PostForm("discord-avatar-url"),
+		ThreadID:   ctx.PostForm("discord-thread-id"),
+	}
+}
+
 //go:embed new.html
 var transmitterCreationForm string
 
-var globalLogger *log.Logger
+var globalLogger *slog.Logger
 
-func SetGlobalLogger(logger *log.Logger) {
+func SetGlobalLogger(logger *slog.Logger) {
 	globalLogger = logger
 }
 
@@ -82,7 +148,7 @@ func NewTransmitterForm(transmitterType string) []byte {
 	templ, err := template.New("").Parse(transmitterCreationForm)
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	var buffer = bytes.Buffer{}
@@ -90,19 +156,19 @@ func NewTransmitterForm(transmitterType string) []byte {
 	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType})
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	return buffer.Bytes()
 }
 
 func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
-	var transmitter = Build(ctx.PostForm("discord-url"), fmt.Sprintf("Transmitter %d", id), true, 0)
+	var transmitter = Build(configFromForm(ctx), fmt.Sprintf("Transmitter %d", id), true, 0)
 	storeFunction(transmitter.GetStorageValue(id))
 	templ, err := template.New("").Parse(transmitterCreationForm)
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	var buffer = bytes.Buffer{}
@@ -110,19 +176,79 @@ func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFu
 	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType, HTMX: template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)})
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	return buffer.Bytes()
 }
 
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID     int
+	Config DiscordConfig
+	Error  string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: config})
+}
+
+// Replaces the webhook of an existing transmitter. The new webhook is checked with Discord before it is saved.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var data = transmitterEditFormData{ID: stored.Id, Config: configFromForm(ctx)}
+
+	var check = DiscordAdvanceTransmitter{config: data.Config}
+	if err := validateConfig(data.Config); err != nil {
+		data.Error = "Invalid Discord webhook: " + err.Error()
+		return renderEditForm(data)
+	}
+	if _, err := check.getHookInfo(); err != nil {
+		data.Error = "Invalid Discord webhook: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	var transmitter = Build(data.Config, fmt.Sprintf("Transmitter %d", stored.Id), stored.Active, stored.TransmitCount)
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+// Looks up the webhook name. Bounded as every rebuild of a stored transmitter waits for it.
+var hookInfoClient = http.Client{Timeout: 10 * time.Second}
+
 func (trans *DiscordAdvanceTransmitter) getHookInfo() (DiscordHookInfo, error) {
 	var hookInfo = DiscordHookInfo{}
-	resp, err := http.Get(trans.discord)
+	resp, err := hookInfoClient.Get(string(trans.config.WebhookURL))
 	if err != nil {
-		return hookInfo, err
+		return hookInfo, structs.WithoutURL(err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return hookInfo, fmt.Errorf("discord returned response other than 200. Response: %s", resp.Status)
+	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -136,31 +262,56 @@ func (trans *DiscordAdvanceTransmitter) getHookInfo() (DiscordHookInfo, error) {
 	return hookInfo, nil
 }
 
-func (trans *DiscordAdvanceTransmitter) Transmit(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) {
+// Webhook URL to post to. Includes the thread if one is set.
+func (trans *DiscordAdvanceTransmitter) executeURL() string {
+	if len(trans.config.ThreadID) == 0 {
+		return string(trans.config.WebhookURL)
+	}
+	webhookURL, err := url.Parse(string(trans.config.WebhookURL))
+	if err != nil {
+		return string(trans.config.WebhookURL)
+	}
+	var query = webhookURL.Query()
+	query.Set("thread_id", trans.config.ThreadID)
+	webhookURL.RawQuery = query.Encode()
+	return webhookURL.String()
+}
+
+func (trans *DiscordAdvanceTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
 	username := trans.username
 	application, err := server.GetApplication(msg.Appid)
 	if err == nil {
 		username = application.Name
 	}
 
-	var discordEmbed = DiscordEmbedStructure{Title: msg.Title, Description: msg.Message}
+	var discordEmbed = DiscordEmbedStructure{Title: msg.Title, Description: msg.Message, Url: msg.ClickURL()}
+	if len(msg.BigImageURL()) > 0 {
+		discordEmbed.Image = &DiscordEmbedImage{Url: msg.BigImageURL()}
+	}
 
-	var discordPayload = DiscordWebhookPayload{Username: username, Embeds: []DiscordEmbedStructure{discordEmbed}}
+	var discordPayload = DiscordWebhookPayload{Username: username, AvatarURL: trans.config.AvatarURL, Embeds: []DiscordEmbedStructure{discordEmbed}}
 
 	discordBytePayload, err := json.Marshal(&discordPayload)
 	if err != nil {
-		globalLogger.Println("Failed To Build Discord Webhook Payload:", err.Error())
-		return
+		return fmt.Errorf("failed to build Discord webhook payload: %w", err)
 	}
-	resp, err := http.Post(trans.discord, "application/json", bytes.NewReader(discordBytePayload))
+	req, err := http.NewRequestWithContext(ctx, "POST", trans.executeURL(), bytes.NewReader(discordBytePayload))
 	if err != nil {
-		globalLogger.Println("Failed to Send Discord Webhook:", err.Error())
-		return
-	} else if resp.StatusCode != http.StatusNoContent {
-		globalLogger.Println("Discord Webhook returned response other than 204. Response:", resp.Status)
-	} else {
-		trans.transmitCount++
+		return fmt.Errorf("failed to build Discord webhook request: %w", structs.WithoutURL(err))
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Discord webhook: %w", structs.WithoutURL(err))
+	}
+	defer resp.Body.Close()
+	structs.RecordResponse(ctx, resp.Status)
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("discord webhook returned response other than 204. Response: %s", resp.Status)
 	}
+	trans.transmitCount++
+	return nil
 }
 
 //go:embed card.html
@@ -170,17 +321,19 @@ func (trans DiscordAdvanceTransmitter) HTMLCard(id int) string {
 
 	template, err := template.New("").Parse(card)
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 		return err.Error()
 	}
 	writer := bytes.Buffer{}
 	type temp struct {
 		Username   string
 		DiscordURL string
+		AvatarURL  string
+		ThreadID   string
 		ID         int
 		Status     string
 	}
-	data := temp{ID: id, Username: trans.username, DiscordURL: trans.discord}
+	data := temp{ID: id, Username: trans.username, DiscordURL: string(trans.config.WebhookURL), AvatarURL: trans.config.AvatarURL, ThreadID: trans.config.ThreadID}
 
 	if trans.Active() {
 		data.Status = "checked"
@@ -190,7 +343,7 @@ func (trans DiscordAdvanceTransmitter) HTMLCard(id int) string {
 
 	err = template.Execute(&writer, data)
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 		return err.Error()
 	}
 
@@ -198,7 +351,9 @@ func (trans DiscordAdvanceTransmitter) HTMLCard(id int) string {
 }
 
 func (trans DiscordAdvanceTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
-	return structs.TransmitterStorage{Id: id, URLorTOKEN: trans.discord, TransmitterType: "discord-advance", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "discord-advance", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
 }
 
 func (trans DiscordAdvanceTransmitter) Active() bool {
@@ -0,0 +1,336 @@
+package gotifyTransmitter
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+// Extras key added to every forwarded message. Messages carrying it are never forwarded again to prevent loops.
+const FederationExtrasKey = "gotify-relay::federation"
+
+type GotifyTransmitter struct {
+	config        GotifyConfig
+	status        bool
+	transmitCount int
+}
+
+// Layout version of GotifyConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for a Gotify transmitter.
+type GotifyConfig struct {
+	ServerURL     string
+	AppToken      storage.Secret
+	PrefixAppName bool
+}
+
+type GotifyMessagePayload struct {
+	Title    string         `json:"title"`
+	Message  string         `json:"message"`
+	Priority int            `json:"priority"`
+	Extras   map[string]any `json:"extras,omitempty"`
+}
+
+func Build(config GotifyConfig, status bool, count int) GotifyTransmitter {
+	config.ServerURL = strings.TrimSuffix(config.ServerURL, "/")
+	return GotifyTransmitter{config: config, status: status, transmitCount: count}
+}
+
+// Transmitters stored before typed configs existed kept the same config encoded as JSON in URLorTOKEN.
+func migrateLegacyConfig(urlOrToken string) (GotifyConfig, error) {
+	var config GotifyConfig
+	err := json.Unmarshal([]byte(urlOrToken), &config)
+	return config, err
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (GotifyConfig, error) {
+	return structs.DecodeConfig(stored, migrateLegacyConfig)
+}
+
+func validateConfig(config GotifyConfig) error {
+	if !structs.IsHTTPURL(config.ServerURL) {
+		return errors.New("the server URL must start with http:// or https://")
+	}
+	if len(config.AppToken) == 0 {
+		return errors.New("an application token is required")
+	}
+	return nil
+}
+
+// Checks stored settings without contacting the server.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*GotifyTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+//go:embed new.html
+var transmitterCreationForm string
+
+var globalLogger *slog.Logger
+
+func SetGlobalLogger(logger *slog.Logger) {
+	globalLogger = logger
+}
+
+type transmitterCreationFormData struct {
+	Type   string
+	HTMX   template.HTML
+	Config GotifyConfig
+	Error  string
+}
+
+func NewTransmitterForm(transmitterType string) []byte {
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType})
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
+	var transmitter = Build(configFromForm(ctx), true, 0)
+
+	// The server must be reachable before the transmitter is stored, like when it is edited.
+	var data = transmitterCreationFormData{Type: transmitterType}
+	var err = validateConfig(transmitter.config)
+	if err == nil {
+		err = transmitter.checkServer()
+	}
+	if err != nil {
+		data.Config = transmitter.config
+		data.Error = "Invalid Gotify server: " + err.Error()
+	} else {
+		storeFunction(transmitter.GetStorageValue(id))
+		data.HTMX = template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)
+	}
+
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func configFromForm(ctx *gin.Context) GotifyConfig {
+	return GotifyConfig{
+		ServerURL:     ctx.PostForm("gotify-url"),
+		AppToken:      storage.Secret(ctx.PostForm("gotify-token")),
+		PrefixAppName: ctx.PostForm("gotify-prefix") == "on",
+	}
+}
+
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID     int
+	Config GotifyConfig
+	Error  string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: transmitter.config})
+}
+
+// Replaces the settings of an existing transmitter. The server must be reachable before the settings are saved.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var transmitter = Build(configFromForm(ctx), stored.Active, stored.TransmitCount)
+	var data = transmitterEditFormData{ID: stored.Id, Config: transmitter.config}
+
+	var err = validateConfig(transmitter.config)
+	if err == nil {
+		err = transmitter.checkServer()
+	}
+	if err != nil {
+		data.Error = "Invalid Gotify server: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+// Used to check the server. Creating and editing a transmitter must not hang on an unresponsive server.
+var checkClient = http.Client{Timeout: 10 * time.Second}
+
+// Requests the version of the server. Does not need a token.
+func (trans *GotifyTransmitter) checkServer() error {
+	resp, err := checkClient.Get(trans.config.ServerURL + "/version")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gotify returned response other than 200. Response: %s", resp.Status)
+	}
+	return nil
+}
+
+func (trans *GotifyTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	if _, federated := msg.Extras[FederationExtrasKey]; federated {
+		// Already arrived through federation. Forwarding it again could loop between instances.
+		return structs.ErrSkipped
+	}
+
+	var payload = GotifyMessagePayload{Title: msg.Title, Message: msg.Message, Priority: msg.Priority, Extras: maps.Clone(msg.Extras)}
+	if payload.Extras == nil {
+		payload.Extras = map[string]any{}
+	}
+	payload.Extras[FederationExtrasKey] = map[string]any{"appid": msg.Appid, "messageId": msg.Id}
+
+	if trans.config.PrefixAppName {
+		application, err := server.GetApplication(msg.Appid)
+		if err == nil {
+			payload.Title = "[" + application.Name + "] " + payload.Title
+		}
+	}
+
+	gotifyBytePayload, err := json.Marshal(&payload)
+	if err != nil {
+		return fmt.Errorf("failed to build Gotify payload: %w", err)
+	}
+
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, "POST", trans.config.ServerURL+"/message", bytes.NewReader(gotifyBytePayload))
+	if err != nil {
+		return fmt.Errorf("failed to build Gotify request: %w", err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Gotify-Key", string(trans.config.AppToken))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Gotify message: %w", err)
+	}
+	defer resp.Body.Close()
+	structs.RecordResponse(ctx, resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gotify returned response other than 200. Response: %s", resp.Status)
+	}
+	trans.transmitCount++
+	return nil
+}
+
+//go:embed card.html
+var card string
+
+func (trans GotifyTransmitter) HTMLCard(id int) string {
+
+	template, err := template.New("").Parse(card)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+	writer := bytes.Buffer{}
+	type temp struct {
+		ServerURL     string
+		Token         string
+		PrefixAppName bool
+		ID            int
+		Status        string
+	}
+	data := temp{ID: id, ServerURL: trans.config.ServerURL, Token: string(trans.config.AppToken), PrefixAppName: trans.config.PrefixAppName}
+
+	if trans.Active() {
+		data.Status = "checked"
+	} else {
+		data.Status = ""
+	}
+
+	err = template.Execute(&writer, data)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+
+	return writer.String()
+}
+
+func (trans GotifyTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "gotify", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
+}
+
+func (trans GotifyTransmitter) Active() bool {
+	return trans.status
+}
+
+func (trans *GotifyTransmitter) SetStatus(active bool) {
+	trans.status = active
+}
+
+func (trans *GotifyTransmitter) GetTransmitCount() int {
+	return trans.transmitCount
+}
@@ -0,0 +1,55 @@
+package gotifyTransmitter
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func createFromForm(form url.Values) (bool, string) {
+	SetGlobalLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	gin.SetMode(gin.TestMode)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	ctx.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var stored = false
+	var page = CreateTransmitterFromForm("gotify", ctx, func(transmitter structs.TransmitterStorage) int {
+		stored = true
+		return 1
+	}, 1)
+	return stored, string(page)
+}
+
+func TestCreateChecksServer(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"version":"2.6.0"}`))
+	}))
+	defer stub.Close()
+
+	stored, page := createFromForm(url.Values{"gotify-url": {stub.URL}, "gotify-token": {"app-token"}})
+	assert.True(t, stored)
+	assert.NotContains(t, page, "Invalid Gotify server")
+
+	stored, page = createFromForm(url.Values{"gotify-url": {stub.URL}})
+	assert.False(t, stored)
+	assert.Contains(t, page, "Invalid Gotify server")
+
+	stub.Close()
+	stored, page = createFromForm(url.Values{"gotify-url": {stub.URL}, "gotify-token": {"app-token"}})
+	assert.False(t, stored)
+	assert.Contains(t, page, "Invalid Gotify server")
+	assert.Contains(t, page, "app-token")
+}
@@ -2,19 +2,20 @@ package logTransmitter
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 
 	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
 	"github.com/CEKlopfenstein/gotify-repeater/structs"
 	"github.com/gin-gonic/gin"
 )
 
-var globalLogger *log.Logger
+var globalLogger *slog.Logger
 
-func SetGlobalLogger(logger *log.Logger) {
+func SetGlobalLogger(logger *slog.Logger) {
 	globalLogger = logger
 }
 
@@ -23,9 +24,11 @@ type LogTransmittor struct {
 	transmitCount int
 }
 
-func (trans *LogTransmittor) Transmit(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) {
-	globalLogger.Println("LogTransmittor, MSG:", msg.Message, "Priority:", msg.Priority, "Raw:", msg)
+func (trans *LogTransmittor) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	globalLogger.Info("LogTransmittor", "message", msg.Message, "priority", msg.Priority, "raw", msg)
+	structs.RecordResponse(ctx, "Written to the Gotify log")
 	trans.transmitCount++
+	return nil
 }
 
 //go:embed card.html
@@ -38,6 +41,12 @@ func Build(status bool, count int) LogTransmittor {
 	return transmitter
 }
 
+// The log transmitter has no settings. Only its status and count are restored.
+func Rehydrate(stored structs.TransmitterStorage) (*LogTransmittor, error) {
+	var transmitter = Build(stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
 //go:embed new.html
 var transmitterCreationForm string
 
@@ -50,7 +59,7 @@ func NewTransmitterForm(transmitterType string) []byte {
 	templ, err := template.New("").Parse(transmitterCreationForm)
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	var buffer = bytes.Buffer{}
@@ -58,7 +67,7 @@ func NewTransmitterForm(transmitterType string) []byte {
 	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType})
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	return buffer.Bytes()
@@ -70,7 +79,7 @@ func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFu
 	templ, err := template.New("").Parse(transmitterCreationForm)
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	var buffer = bytes.Buffer{}
@@ -78,7 +87,7 @@ func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFu
 	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType, HTMX: template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)})
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	return buffer.Bytes()
@@ -87,7 +96,7 @@ func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFu
 func (trans LogTransmittor) HTMLCard(id int) string {
 	template, err := template.New("").Parse(card)
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 		return err.Error()
 	}
 	writer := bytes.Buffer{}
@@ -104,7 +113,7 @@ func (trans LogTransmittor) HTMLCard(id int) string {
 
 	err = template.Execute(&writer, data)
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 		return err.Error()
 	}
 
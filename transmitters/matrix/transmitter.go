@@ -0,0 +1,394 @@
+package matrixTransmitter
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/markdown"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+type MatrixTransmitter struct {
+	config        MatrixConfig
+	status        bool
+	transmitCount int
+}
+
+// Layout version of MatrixConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for a Matrix transmitter.
+type MatrixConfig struct {
+	// Base URL of the homeserver such as https://matrix.example.org. Without the /_matrix path.
+	HomeserverURL string
+	AccessToken   storage.Secret
+	// Room ID such as !abcdefg:example.org. The account of the access token must have joined the room.
+	RoomID string
+	// Messages with a priority below this are sent as m.notice, which clients and bots treat as less important.
+	NoticeBelowPriority int
+}
+
+// Content of an m.room.message event.
+type MatrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// Error returned by the client-server API.
+type MatrixError struct {
+	ErrCode      string `json:"errcode"`
+	Error        string `json:"error"`
+	RetryAfterMs int    `json:"retry_after_ms"`
+}
+
+func Build(config MatrixConfig, status bool, count int) MatrixTransmitter {
+	return MatrixTransmitter{config: config, status: status, transmitCount: count}
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (MatrixConfig, error) {
+	// No transmitters of this type were stored before typed configs existed.
+	return structs.DecodeConfig[MatrixConfig](stored, nil)
+}
+
+func validateConfig(config MatrixConfig) error {
+	if !structs.IsHTTPURL(config.HomeserverURL) {
+		return errors.New("the homeserver URL must start with http:// or https://")
+	}
+	if len(config.AccessToken) == 0 || len(config.RoomID) == 0 {
+		return errors.New("an access token and room ID are required")
+	}
+	return nil
+}
+
+// Checks stored settings without contacting the homeserver. See checkRoom for the full check.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*MatrixTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+func configFromForm(ctx *gin.Context) MatrixConfig {
+	noticePriority, _ := strconv.Atoi(ctx.PostForm("matrix-notice-priority"))
+	return MatrixConfig{
+		HomeserverURL:       strings.TrimSuffix(strings.TrimSpace(ctx.PostForm("matrix-homeserver")), "/"),
+		AccessToken:         storage.Secret(strings.TrimSpace(ctx.PostForm("matrix-token"))),
+		RoomID:              strings.TrimSpace(ctx.PostForm("matrix-room")),
+		NoticeBelowPriority: noticePriority,
+	}
+}
+
+//go:embed new.html
+var transmitterCreationForm string
+
+var globalLogger *slog.Logger
+
+func SetGlobalLogger(logger *slog.Logger) {
+	globalLogger = logger
+}
+
+type transmitterCreationFormData struct {
+	Type  string
+	HTMX  template.HTML
+	Error string
+}
+
+func renderCreationForm(data transmitterCreationFormData) []byte {
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func NewTransmitterForm(transmitterType string) []byte {
+	return renderCreationForm(transmitterCreationFormData{Type: transmitterType})
+}
+
+// Creates the transmitter once the homeserver confirms the account can post to the room.
+func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
+	var transmitter = Build(configFromForm(ctx), true, 0)
+	if err := transmitter.checkRoom(ctx.Request.Context()); err != nil {
+		return renderCreationForm(transmitterCreationFormData{Type: transmitterType, Error: "Invalid homeserver, access token or room ID: " + err.Error()})
+	}
+
+	storeFunction(transmitter.GetStorageValue(id))
+	return renderCreationForm(transmitterCreationFormData{Type: transmitterType, HTMX: template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)})
+}
+
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID     int
+	Config MatrixConfig
+	Error  string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: config})
+}
+
+// Replaces the settings of an existing transmitter. The account must have joined the room before the settings are saved.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var transmitter = Build(configFromForm(ctx), stored.Active, stored.TransmitCount)
+	var data = transmitterEditFormData{ID: stored.Id, Config: transmitter.config}
+
+	if err := transmitter.checkRoom(ctx.Request.Context()); err != nil {
+		data.Error = "Invalid homeserver, access token or room ID: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+// URL of a client-server API endpoint within the room. The path segments are escaped.
+func (trans *MatrixTransmitter) roomURL(segments ...string) string {
+	var path = trans.config.HomeserverURL + "/_matrix/client/v3/rooms/" + url.PathEscape(trans.config.RoomID)
+	for _, segment := range segments {
+		path += "/" + url.PathEscape(segment)
+	}
+	return path
+}
+
+// Sends a request to the homeserver authenticated with the access token.
+func (trans *MatrixTransmitter) do(ctx context.Context, method string, endpoint string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.New("failed to build Matrix request")
+	}
+	req.Header.Add("Authorization", "Bearer "+string(trans.config.AccessToken))
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// Describes an unsuccessful response using the error the homeserver sent along.
+func responseError(resp *http.Response, matrixError MatrixError) error {
+	if len(matrixError.ErrCode) > 0 {
+		return fmt.Errorf("matrix returned response other than 200. Response: %s %s %s", resp.Status, matrixError.ErrCode, matrixError.Error)
+	}
+	return fmt.Errorf("matrix returned response other than 200. Response: %s", resp.Status)
+}
+
+// Lists the members of the room. Fails if the homeserver can not be reached, the token is wrong or the account has not joined the room.
+func (trans *MatrixTransmitter) checkRoom(ctx context.Context) error {
+	if err := validateConfig(trans.config); err != nil {
+		return err
+	}
+	resp, err := trans.do(ctx, "GET", trans.roomURL("joined_members"), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var matrixError MatrixError
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&matrixError)
+		return responseError(resp, matrixError)
+	}
+	return nil
+}
+
+// Converts newlines of HTML produced by markdown.ToHTML into line breaks, as clients render formatted_body as regular HTML.
+// Newlines within code blocks are left alone.
+func lineBreaks(text string) string {
+	var parts = strings.Split(text, "<pre>")
+	for index, part := range parts {
+		var code, rest, found = strings.Cut(part, "</pre>")
+		if index == 0 || !found {
+			parts[index] = strings.ReplaceAll(part, "\n", "<br>")
+			continue
+		}
+		parts[index] = code + "</pre>" + strings.ReplaceAll(strings.TrimPrefix(rest, "\n"), "\n", "<br>")
+	}
+	return strings.Join(parts, "<pre>")
+}
+
+func (trans *MatrixTransmitter) buildMessage(msg structs.GotifyMessageStruct) MatrixMessage {
+	var message = MatrixMessage{MsgType: "m.text"}
+	if msg.Priority < trans.config.NoticeBelowPriority {
+		message.MsgType = "m.notice"
+	}
+
+	var body = msg.Message
+	if msg.IsMarkdown() {
+		body = markdown.Strip(msg.Message)
+		message.Format = "org.matrix.custom.html"
+		message.FormattedBody = lineBreaks(markdown.ToHTML(msg.Message))
+		if len(msg.Title) > 0 {
+			message.FormattedBody = "<strong>" + html.EscapeString(msg.Title) + "</strong><br>" + message.FormattedBody
+		}
+		if len(msg.ClickURL()) > 0 {
+			message.FormattedBody += `<br><a href="` + html.EscapeString(msg.ClickURL()) + `">Open</a>`
+		}
+	}
+
+	if len(msg.Title) > 0 {
+		body = msg.Title + "\n" + body
+	}
+	if len(msg.ClickURL()) > 0 {
+		body += "\n" + msg.ClickURL()
+	}
+	message.Body = body
+	return message
+}
+
+// Transaction ID of the event sent for the message. Matrix drops a repeated send with the same ID,
+// so a retried delivery of a message the homeserver already accepted does not post it twice.
+func transactionID(msg structs.GotifyMessageStruct) string {
+	if msg.Id == 0 {
+		// Test messages have no ID. Every one of them is sent.
+		return "gotify-relay-test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return "gotify-relay-" + strconv.Itoa(msg.Id)
+}
+
+func (trans *MatrixTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	body, err := json.Marshal(trans.buildMessage(msg))
+	if err != nil {
+		return fmt.Errorf("failed to build Matrix message: %w", err)
+	}
+
+	resp, err := trans.do(ctx, "PUT", trans.roomURL("send", "m.room.message", transactionID(msg)), body)
+	if err != nil {
+		return fmt.Errorf("failed to send Matrix message: %w", err)
+	}
+	defer resp.Body.Close()
+	structs.RecordResponse(ctx, resp.Status)
+
+	if resp.StatusCode == http.StatusOK {
+		trans.transmitCount++
+		return nil
+	}
+
+	var matrixError MatrixError
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&matrixError)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		var retryAfter = time.Duration(matrixError.RetryAfterMs) * time.Millisecond
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return &structs.RetryAfterError{
+			RetryAfter: retryAfter,
+			Err:        fmt.Errorf("matrix rate limited the account: %s", matrixError.Error),
+		}
+	}
+	return responseError(resp, matrixError)
+}
+
+//go:embed card.html
+var card string
+
+func (trans MatrixTransmitter) HTMLCard(id int) string {
+
+	template, err := template.New("").Parse(card)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+	writer := bytes.Buffer{}
+	type temp struct {
+		HomeserverURL       string
+		RoomID              string
+		Token               string
+		NoticeBelowPriority int
+		ID                  int
+		Status              string
+	}
+	data := temp{ID: id, HomeserverURL: trans.config.HomeserverURL, RoomID: trans.config.RoomID, Token: string(trans.config.AccessToken), NoticeBelowPriority: trans.config.NoticeBelowPriority}
+
+	if trans.Active() {
+		data.Status = "checked"
+	} else {
+		data.Status = ""
+	}
+
+	err = template.Execute(&writer, data)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+
+	return writer.String()
+}
+
+func (trans MatrixTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "matrix", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
+}
+
+func (trans MatrixTransmitter) Active() bool {
+	return trans.status
+}
+
+func (trans *MatrixTransmitter) SetStatus(active bool) {
+	trans.status = active
+}
+
+func (trans *MatrixTransmitter) GetTransmitCount() int {
+	return trans.transmitCount
+}
@@ -0,0 +1,70 @@
+package matrixTransmitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransmit(t *testing.T) {
+	var received []MatrixMessage
+	var paths []string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "Bearer syt_secret", r.Header.Get("Authorization"))
+		paths = append(paths, r.URL.EscapedPath())
+		var message MatrixMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		received = append(received, message)
+		w.Write([]byte(`{"event_id":"$event"}`))
+	}))
+	defer stub.Close()
+
+	trans := Build(MatrixConfig{HomeserverURL: stub.URL, AccessToken: "syt_secret", RoomID: "!room:example.org", NoticeBelowPriority: 4}, true, 0)
+	markdownMessage := structs.GotifyMessageStruct{Id: 17, Title: "Backup", Message: "Done in **1.5s**\nAll good", Priority: 5,
+		Extras: map[string]any{"client::display": map[string]any{"contentType": "text/markdown"}}}
+	assert.NoError(t, trans.Transmit(context.Background(), markdownMessage, gotify_api.GotifyApi{}))
+	// A retry of the same message reuses its transaction ID.
+	assert.NoError(t, trans.Transmit(context.Background(), markdownMessage, gotify_api.GotifyApi{}))
+	assert.NoError(t, trans.Transmit(context.Background(), structs.GotifyMessageStruct{Id: 18, Title: "Disk", Message: "80% <full>", Priority: 2}, gotify_api.GotifyApi{}))
+
+	assert.Equal(t, []string{
+		"/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/gotify-relay-17",
+		"/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/gotify-relay-17",
+		"/_matrix/client/v3/rooms/%21room:example.org/send/m.room.message/gotify-relay-18",
+	}, paths)
+	if assert.Len(t, received, 3) {
+		assert.Equal(t, MatrixMessage{
+			MsgType:       "m.text",
+			Body:          "Backup\nDone in 1.5s\nAll good",
+			Format:        "org.matrix.custom.html",
+			FormattedBody: "<strong>Backup</strong><br>Done in <b>1.5s</b><br>All good",
+		}, received[0])
+		assert.Equal(t, MatrixMessage{MsgType: "m.notice", Body: "Disk\n80% <full>"}, received[2])
+	}
+	assert.Equal(t, 3, trans.GetTransmitCount())
+}
+
+func TestTransmitRetryAfter(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"errcode":"M_LIMIT_EXCEEDED","error":"Too many requests","retry_after_ms":2500}`))
+	}))
+	defer stub.Close()
+
+	trans := Build(MatrixConfig{HomeserverURL: stub.URL, AccessToken: "syt_secret", RoomID: "!room:example.org"}, true, 0)
+	err := trans.Transmit(context.Background(), structs.GotifyMessageStruct{Id: 1, Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	var retryAfter *structs.RetryAfterError
+	assert.True(t, errors.As(err, &retryAfter))
+	assert.Equal(t, 2500*time.Millisecond, retryAfter.RetryAfter)
+	assert.Equal(t, 0, trans.GetTransmitCount())
+}
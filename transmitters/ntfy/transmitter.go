@@ -0,0 +1,338 @@
+package ntfyTransmitter
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+const DefaultServerURL = "https://ntfy.sh"
+
+type NtfyTransmitter struct {
+	config        NtfyConfig
+	status        bool
+	transmitCount int
+}
+
+// Layout version of NtfyConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for a ntfy transmitter.
+type NtfyConfig struct {
+	ServerURL string
+	Topic     string
+	// Basic auth. Only used when no AccessToken is set.
+	Username string
+	Password storage.Secret
+	// Sent as a bearer token. Takes precedence over Username and Password.
+	AccessToken storage.Secret
+	// Tags messages with the name of the Gotify application they came from.
+	TagApplication bool
+}
+
+// Error returned by ntfy.
+type NtfyError struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+func Build(config NtfyConfig, status bool, count int) NtfyTransmitter {
+	if len(config.ServerURL) == 0 {
+		config.ServerURL = DefaultServerURL
+	}
+	return NtfyTransmitter{config: config, status: status, transmitCount: count}
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (NtfyConfig, error) {
+	// No transmitters of this type were stored before typed configs existed.
+	return structs.DecodeConfig[NtfyConfig](stored, nil)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*NtfyTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+func configFromForm(ctx *gin.Context) NtfyConfig {
+	return NtfyConfig{
+		ServerURL:      strings.TrimSuffix(strings.TrimSpace(ctx.PostForm("ntfy-server")), "/"),
+		Topic:          strings.TrimSpace(ctx.PostForm("ntfy-topic")),
+		Username:       strings.TrimSpace(ctx.PostForm("ntfy-username")),
+		Password:       storage.Secret(ctx.PostForm("ntfy-password")),
+		AccessToken:    storage.Secret(strings.TrimSpace(ctx.PostForm("ntfy-token"))),
+		TagApplication: ctx.PostForm("ntfy-tag-application") == "on",
+	}
+}
+
+// Publishing is the only way to ask ntfy about a topic and would notify subscribers. So only the settings themselves are checked.
+func validateConfig(config NtfyConfig) error {
+	serverURL, err := url.Parse(config.ServerURL)
+	if err != nil {
+		return errors.New("the server URL could not be parsed")
+	}
+	if (serverURL.Scheme != "https" && serverURL.Scheme != "http") || len(serverURL.Host) == 0 {
+		return errors.New("the server URL must start with http:// or https://")
+	}
+	if len(config.Topic) == 0 || strings.ContainsAny(config.Topic, "/?#") {
+		return errors.New("a topic name without slashes is required")
+	}
+	return nil
+}
+
+// Checks stored settings the same way the forms do.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+//go:embed new.html
+var transmitterCreationForm string
+
+var globalLogger *slog.Logger
+
+func SetGlobalLogger(logger *slog.Logger) {
+	globalLogger = logger
+}
+
+type transmitterCreationFormData struct {
+	Type  string
+	HTMX  template.HTML
+	Error string
+}
+
+func renderCreationForm(data transmitterCreationFormData) []byte {
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func NewTransmitterForm(transmitterType string) []byte {
+	return renderCreationForm(transmitterCreationFormData{Type: transmitterType})
+}
+
+func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
+	var transmitter = Build(configFromForm(ctx), true, 0)
+	if err := validateConfig(transmitter.config); err != nil {
+		return renderCreationForm(transmitterCreationFormData{Type: transmitterType, Error: "Invalid ntfy settings: " + err.Error()})
+	}
+
+	storeFunction(transmitter.GetStorageValue(id))
+	return renderCreationForm(transmitterCreationFormData{Type: transmitterType, HTMX: template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)})
+}
+
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID     int
+	Config NtfyConfig
+	Error  string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: transmitter.config})
+}
+
+// Replaces the settings of an existing transmitter.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var transmitter = Build(configFromForm(ctx), stored.Active, stored.TransmitCount)
+	var data = transmitterEditFormData{ID: stored.Id, Config: transmitter.config}
+	if err := validateConfig(transmitter.config); err != nil {
+		data.Error = "Invalid ntfy settings: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+// Maps Gotify's 0 to 10 priority onto ntfy's 1 (min) to 5 (max) scale.
+// Gotify clients treat 0 as silent, 1 to 3 as low, 4 to 7 as normal and 8 and up as high.
+func ntfyPriority(priority int) int {
+	switch {
+	case priority >= 10:
+		return 5
+	case priority >= 8:
+		return 4
+	case priority >= 4:
+		return 3
+	case priority >= 1:
+		return 2
+	}
+	return 1
+}
+
+// Header values have to be ASCII. Other text is encoded as RFC 2047, which ntfy decodes.
+func encodeHeader(value string) string {
+	return mime.BEncoding.Encode("UTF-8", value)
+}
+
+func (trans *NtfyTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", trans.config.ServerURL+"/"+url.PathEscape(trans.config.Topic), strings.NewReader(msg.Message))
+	if err != nil {
+		return errors.New("failed to build ntfy request")
+	}
+	if len(msg.Title) > 0 {
+		req.Header.Set("Title", encodeHeader(msg.Title))
+	}
+	req.Header.Set("Priority", strconv.Itoa(ntfyPriority(msg.Priority)))
+	if msg.IsMarkdown() {
+		req.Header.Set("Markdown", "yes")
+	}
+	if len(msg.ClickURL()) > 0 {
+		req.Header.Set("Click", msg.ClickURL())
+	}
+	if len(msg.BigImageURL()) > 0 {
+		req.Header.Set("Attach", msg.BigImageURL())
+	}
+	if trans.config.TagApplication {
+		application, err := server.GetApplication(msg.Appid)
+		if err == nil && len(application.Name) > 0 {
+			// Tags are separated by commas.
+			req.Header.Set("Tags", encodeHeader(strings.ReplaceAll(application.Name, ",", " ")))
+		}
+	}
+	if len(trans.config.AccessToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+string(trans.config.AccessToken))
+	} else if len(trans.config.Username) > 0 {
+		req.SetBasicAuth(trans.config.Username, string(trans.config.Password))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish to ntfy: %w", structs.WithoutURL(err))
+	}
+	defer resp.Body.Close()
+	structs.RecordResponse(ctx, resp.Status)
+
+	if resp.StatusCode == http.StatusOK {
+		trans.transmitCount++
+		return nil
+	}
+
+	var ntfyError NtfyError
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&ntfyError)
+	err = fmt.Errorf("ntfy returned response other than 200. Response: %s %s", resp.Status, ntfyError.Error)
+	if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); resp.StatusCode == http.StatusTooManyRequests && parseErr == nil {
+		return &structs.RetryAfterError{RetryAfter: time.Duration(seconds) * time.Second, Err: err}
+	}
+	return err
+}
+
+//go:embed card.html
+var card string
+
+func (trans NtfyTransmitter) HTMLCard(id int) string {
+
+	template, err := template.New("").Parse(card)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+	writer := bytes.Buffer{}
+	type temp struct {
+		ServerURL      string
+		Topic          string
+		Auth           string
+		TagApplication bool
+		ID             int
+		Status         string
+	}
+	data := temp{ID: id, ServerURL: trans.config.ServerURL, Topic: trans.config.Topic, Auth: "None", TagApplication: trans.config.TagApplication}
+	if len(trans.config.AccessToken) > 0 {
+		data.Auth = "Access Token"
+	} else if len(trans.config.Username) > 0 {
+		data.Auth = "Username " + trans.config.Username
+	}
+
+	if trans.Active() {
+		data.Status = "checked"
+	} else {
+		data.Status = ""
+	}
+
+	err = template.Execute(&writer, data)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+
+	return writer.String()
+}
+
+func (trans NtfyTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "ntfy", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
+}
+
+func (trans NtfyTransmitter) Active() bool {
+	return trans.status
+}
+
+func (trans *NtfyTransmitter) SetStatus(active bool) {
+	trans.status = active
+}
+
+func (trans *NtfyTransmitter) GetTransmitCount() int {
+	return trans.transmitCount
+}
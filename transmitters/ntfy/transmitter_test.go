@@ -0,0 +1,109 @@
+package ntfyTransmitter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNtfyPriority(t *testing.T) {
+	for priority, expected := range map[int]int{-1: 1, 0: 1, 1: 2, 3: 2, 4: 3, 7: 3, 8: 4, 9: 4, 10: 5, 15: 5} {
+		assert.Equal(t, expected, ntfyPriority(priority), "priority %d", priority)
+	}
+}
+
+func TestTransmit(t *testing.T) {
+	var received *http.Request
+	var body string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/application" {
+			w.Write([]byte(`[{"id":3,"name":"Backups, nightly"}]`))
+			return
+		}
+		received = r
+		content, _ := io.ReadAll(r.Body)
+		body = string(content)
+		w.Write([]byte(`{"id":"abc","event":"message"}`))
+	}))
+	defer stub.Close()
+
+	trans := Build(NtfyConfig{ServerURL: stub.URL, Topic: "alerts", Username: "phil", Password: "hunter2", TagApplication: true}, true, 0)
+	msg := structs.GotifyMessageStruct{Appid: 3, Title: "Sauvegarde terminée", Message: "Done", Priority: 8,
+		Extras: map[string]any{"client::notification": map[string]any{"click": map[string]any{"url": "https://example.org/backups"}}}}
+	err := trans.Transmit(context.Background(), msg, gotify_api.SetupGotifyApi(stub.URL, "client-token"))
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, received) {
+		assert.Equal(t, "/alerts", received.URL.Path)
+		assert.Equal(t, "Done", body)
+		assert.Equal(t, "=?UTF-8?b?U2F1dmVnYXJkZSB0ZXJtaW7DqWU=?=", received.Header.Get("Title"))
+		assert.Equal(t, "4", received.Header.Get("Priority"))
+		assert.Equal(t, "Backups  nightly", received.Header.Get("Tags"))
+		assert.Equal(t, "https://example.org/backups", received.Header.Get("Click"))
+		username, password, ok := received.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "phil", username)
+		assert.Equal(t, "hunter2", password)
+	}
+	assert.Equal(t, 1, trans.GetTransmitCount())
+}
+
+func TestTransmitBearerToken(t *testing.T) {
+	var authorization string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		w.Write([]byte(`{}`))
+	}))
+	defer stub.Close()
+
+	trans := Build(NtfyConfig{ServerURL: stub.URL, Topic: "alerts", Username: "phil", Password: "hunter2", AccessToken: "tk_secret"}, true, 0)
+	err := trans.Transmit(context.Background(), structs.GotifyMessageStruct{Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer tk_secret", authorization)
+}
+
+func TestTransmitRetryAfter(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "12")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"code":42901,"http":429,"error":"limit reached: too many requests"}`))
+	}))
+	defer stub.Close()
+
+	trans := Build(NtfyConfig{ServerURL: stub.URL, Topic: "alerts"}, true, 0)
+	err := trans.Transmit(context.Background(), structs.GotifyMessageStruct{Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	var retryAfter *structs.RetryAfterError
+	assert.True(t, errors.As(err, &retryAfter))
+	assert.Equal(t, 12*time.Second, retryAfter.RetryAfter)
+	assert.ErrorContains(t, err, "too many requests")
+	assert.Equal(t, 0, trans.GetTransmitCount())
+}
+
+func TestTransmitErrorLeavesOutURL(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	stub.Close()
+
+	// Anyone who knows the topic of a public server can read it, so it must not end up in the outbox.
+	trans := Build(NtfyConfig{ServerURL: stub.URL, Topic: "secret-topic"}, true, 0)
+	err := trans.Transmit(context.Background(), structs.GotifyMessageStruct{Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	assert.ErrorContains(t, err, "failed to publish to ntfy")
+	assert.NotContains(t, err.Error(), "secret-topic")
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, validateConfig(NtfyConfig{ServerURL: "https://ntfy.sh", Topic: "alerts"}))
+	assert.NoError(t, validateConfig(NtfyConfig{ServerURL: "http://ntfy.lan:8080", Topic: "alerts"}))
+	assert.EqualError(t, validateConfig(NtfyConfig{ServerURL: "ntfy.sh", Topic: "alerts"}), "the server URL must start with http:// or https://")
+	assert.EqualError(t, validateConfig(NtfyConfig{ServerURL: "https://ntfy.sh", Topic: "a/b"}), "a topic name without slashes is required")
+}
@@ -2,21 +2,25 @@ package pushbulletTransmitter
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/markdown"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
 	"github.com/CEKlopfenstein/gotify-repeater/structs"
 	"github.com/gin-gonic/gin"
 )
 
 type PushBulletTransmitter struct {
 	url           string
-	AccessToken   string
+	config        PushbulletConfig
 	DefaultTitle  string
 	transmitCount int
 	status        bool
@@ -26,19 +30,73 @@ type PushBulletPayload struct {
 	Title string `json:"title"`
 	Body  string `json:"body"`
 	Type  string `json:"type"`
+	Url   string `json:"url,omitempty"`
+	// Sends the push to a single device instead of all of them.
+	DeviceIden string `json:"device_iden,omitempty"`
 }
 
-func Build(accesstoken string, name string, status bool, count int) PushBulletTransmitter {
-	var transmitter = PushBulletTransmitter{url: "https://api.pushbullet.com/v2/pushes", AccessToken: accesstoken, DefaultTitle: name, transmitCount: count, status: status}
+// Layout version of PushbulletConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for a Pushbullet transmitter.
+type PushbulletConfig struct {
+	AccessToken storage.Secret
+	// Identifier of the device pushes are sent to. Empty sends to every device.
+	DeviceIden string
+}
+
+func Build(config PushbulletConfig, name string, status bool, count int) PushBulletTransmitter {
+	var transmitter = PushBulletTransmitter{url: "https://api.pushbullet.com/v2/pushes", config: config, DefaultTitle: name, transmitCount: count, status: status}
 	return transmitter
 }
 
+// Transmitters stored before typed configs existed only kept the access token.
+func migrateLegacyConfig(urlOrToken string) (PushbulletConfig, error) {
+	return PushbulletConfig{AccessToken: storage.Secret(urlOrToken)}, nil
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (PushbulletConfig, error) {
+	return structs.DecodeConfig(stored, migrateLegacyConfig)
+}
+
+func validateConfig(config PushbulletConfig) error {
+	if len(config.AccessToken) == 0 {
+		return errors.New("an access token is required")
+	}
+	return nil
+}
+
+// Checks stored settings without asking Pushbullet about the token.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*PushBulletTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, fmt.Sprintf("Transmitter %d", stored.Id), stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+func configFromForm(ctx *gin.Context) PushbulletConfig {
+	return PushbulletConfig{
+		AccessToken: storage.Secret(ctx.PostForm("pushbullet-token")),
+		DeviceIden:  ctx.PostForm("pushbullet-device"),
+	}
+}
+
 //go:embed new.html
 var transmitterCreationForm string
 
-var globalLogger *log.Logger
+var globalLogger *slog.Logger
 
-func SetGlobalLogger(logger *log.Logger) {
+func SetGlobalLogger(logger *slog.Logger) {
 	globalLogger = logger
 }
 
@@ -51,7 +109,7 @@ func NewTransmitterForm(transmitterType string) []byte {
 	templ, err := template.New("").Parse(transmitterCreationForm)
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	var buffer = bytes.Buffer{}
@@ -59,20 +117,20 @@ func NewTransmitterForm(transmitterType string) []byte {
 	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType})
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	return buffer.Bytes()
 }
 
 func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
-	var transmitter = Build(ctx.PostForm("pushbullet-token"), fmt.Sprintf("Transmitter %d", id), true, 0)
+	var transmitter = Build(configFromForm(ctx), fmt.Sprintf("Transmitter %d", id), true, 0)
 
 	storeFunction(transmitter.GetStorageValue(id))
 	templ, err := template.New("").Parse(transmitterCreationForm)
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	var buffer = bytes.Buffer{}
@@ -80,16 +138,89 @@ func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFu
 	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType, HTMX: template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)})
 
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 	}
 
 	return buffer.Bytes()
 }
 
-func (trans *PushBulletTransmitter) Transmit(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) {
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID     int
+	Config PushbulletConfig
+	Error  string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: config})
+}
+
+// Replaces the access token of an existing transmitter. The new token is checked with Pushbullet before it is saved.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var data = transmitterEditFormData{ID: stored.Id, Config: configFromForm(ctx)}
+
+	var err = validateConfig(data.Config)
+	if err == nil {
+		err = checkAccessToken(string(data.Config.AccessToken))
+	}
+	if err != nil {
+		data.Error = "Invalid access token: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	var transmitter = Build(data.Config, fmt.Sprintf("Transmitter %d", stored.Id), stored.Active, stored.TransmitCount)
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+// Asks Pushbullet for the user the token belongs to.
+func checkAccessToken(accessToken string) error {
+	req, err := http.NewRequest("GET", "https://api.pushbullet.com/v2/users/me", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Access-Token", accessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pushbullet returned response other than 200. Response: %s", resp.Status)
+	}
+	return nil
+}
+
+func (trans *PushBulletTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
 	var pushBulletPayload PushBulletPayload
 	pushBulletPayload.Type = "note"
 	pushBulletPayload.Title = trans.DefaultTitle
+	pushBulletPayload.DeviceIden = trans.config.DeviceIden
 
 	// Attempt to get title
 	application, err := server.GetApplication(msg.Appid)
@@ -97,34 +228,44 @@ func (trans *PushBulletTransmitter) Transmit(msg structs.GotifyMessageStruct, se
 		pushBulletPayload.Title = application.Name
 	}
 
-	pushBulletPayload.Body = msg.Title + "\n" + msg.Message
+	var message = msg.Message
+	if msg.IsMarkdown() {
+		// Pushbullet only displays plain text.
+		message = markdown.Strip(message)
+	}
+	pushBulletPayload.Body = msg.Title + "\n" + message
+
+	if len(msg.ClickURL()) > 0 {
+		pushBulletPayload.Type = "link"
+		pushBulletPayload.Url = msg.ClickURL()
+	}
 
 	pushbulletBytePayload, err := json.Marshal(&pushBulletPayload)
 	if err != nil {
-		globalLogger.Println("Failed To Build Pushbullet Payload:", err.Error())
-		return
+		return fmt.Errorf("failed to build Pushbullet payload: %w", err)
 	}
 
 	client := http.Client{}
-	req, err := http.NewRequest("POST", trans.url, bytes.NewReader(pushbulletBytePayload))
+	req, err := http.NewRequestWithContext(ctx, "POST", trans.url, bytes.NewReader(pushbulletBytePayload))
 
 	if err != nil {
-		globalLogger.Println("Failed To Build Pushbullet Request:", err.Error())
-		return
+		return fmt.Errorf("failed to build Pushbullet request: %w", err)
 	}
 
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Access-Token", trans.AccessToken)
+	req.Header.Add("Access-Token", string(trans.config.AccessToken))
 
 	resp, err := client.Do(req)
 	if err != nil {
-		globalLogger.Println("Failed to Send Pushbullet:", err.Error())
-		return
-	} else if resp.StatusCode != http.StatusOK {
-		globalLogger.Println("Pushbullet returned response other than 200. Response:", resp.Status)
-	} else {
-		trans.transmitCount++
+		return fmt.Errorf("failed to send Pushbullet: %w", err)
+	}
+	defer resp.Body.Close()
+	structs.RecordResponse(ctx, resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("pushbullet returned response other than 200. Response: %s", resp.Status)
 	}
+	trans.transmitCount++
+	return nil
 }
 
 //go:embed card.html
@@ -134,17 +275,18 @@ func (trans PushBulletTransmitter) HTMLCard(id int) string {
 
 	template, err := template.New("").Parse(card)
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 		return err.Error()
 	}
 	writer := bytes.Buffer{}
 	type temp struct {
-		Title  string
-		Token  string
-		ID     int
-		Status string
+		Title      string
+		Token      string
+		DeviceIden string
+		ID         int
+		Status     string
 	}
-	data := temp{ID: id, Title: trans.DefaultTitle, Token: trans.AccessToken}
+	data := temp{ID: id, Title: trans.DefaultTitle, Token: string(trans.config.AccessToken), DeviceIden: trans.config.DeviceIden}
 
 	if trans.Active() {
 		data.Status = "checked"
@@ -154,7 +296,7 @@ func (trans PushBulletTransmitter) HTMLCard(id int) string {
 
 	err = template.Execute(&writer, data)
 	if err != nil {
-		globalLogger.Println(err)
+		globalLogger.Error(err.Error())
 		return err.Error()
 	}
 
@@ -162,7 +304,9 @@ func (trans PushBulletTransmitter) HTMLCard(id int) string {
 }
 
 func (trans PushBulletTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
-	return structs.TransmitterStorage{Id: id, URLorTOKEN: trans.AccessToken, TransmitterType: "pushbullet", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "pushbullet", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
 }
 
 func (trans PushBulletTransmitter) Active() bool {
@@ -0,0 +1,389 @@
+package slackTransmitter
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/markdown"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+type SlackTransmitter struct {
+	config        SlackConfig
+	status        bool
+	transmitCount int
+}
+
+// Layout version of SlackConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for a Slack transmitter.
+type SlackConfig struct {
+	// Incoming webhook URL. Any host is accepted so Slack compatible services and local stubs can be used.
+	WebhookURL storage.Secret
+}
+
+// Longest texts Slack accepts within a header block and a section block.
+const (
+	maxHeaderLength  = 150
+	maxSectionLength = 3000
+)
+
+type SlackPayload struct {
+	// Shown in notifications as blocks within attachments are not.
+	Text        string            `json:"text"`
+	Attachments []SlackAttachment `json:"attachments"`
+}
+
+type SlackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+// A Block Kit block. Only the fields used by the block types sent here.
+type SlackBlock struct {
+	Type     string         `json:"type"`
+	Text     *SlackText     `json:"text,omitempty"`
+	Elements []SlackElement `json:"elements,omitempty"`
+	ImageURL string         `json:"image_url,omitempty"`
+	AltText  string         `json:"alt_text,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Element of a context or actions block. Either a text or a button.
+type SlackElement struct {
+	Type string `json:"type"`
+	// A string for texts and a SlackText for buttons.
+	Text any    `json:"text,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+func Build(config SlackConfig, status bool, count int) SlackTransmitter {
+	return SlackTransmitter{config: config, status: status, transmitCount: count}
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (SlackConfig, error) {
+	// No transmitters of this type were stored before typed configs existed.
+	return structs.DecodeConfig[SlackConfig](stored, nil)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*SlackTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+func configFromForm(ctx *gin.Context) SlackConfig {
+	return SlackConfig{WebhookURL: storage.Secret(strings.TrimSpace(ctx.PostForm("slack-url")))}
+}
+
+// Slack can not be asked about a webhook without posting to it. So only the URL itself is checked.
+func validateConfig(config SlackConfig) error {
+	webhookURL, err := url.Parse(string(config.WebhookURL))
+	if err != nil {
+		return errors.New("the URL could not be parsed")
+	}
+	if (webhookURL.Scheme != "https" && webhookURL.Scheme != "http") || len(webhookURL.Host) == 0 {
+		return errors.New("the URL must start with http:// or https://")
+	}
+	return nil
+}
+
+// Checks stored settings the same way the forms do. Slack is not contacted.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+//go:embed new.html
+var transmitterCreationForm string
+
+var globalLogger *slog.Logger
+
+func SetGlobalLogger(logger *slog.Logger) {
+	globalLogger = logger
+}
+
+type transmitterCreationFormData struct {
+	Type  string
+	HTMX  template.HTML
+	Error string
+}
+
+func renderCreationForm(data transmitterCreationFormData) []byte {
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func NewTransmitterForm(transmitterType string) []byte {
+	return renderCreationForm(transmitterCreationFormData{Type: transmitterType})
+}
+
+func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
+	var config = configFromForm(ctx)
+	if err := validateConfig(config); err != nil {
+		return renderCreationForm(transmitterCreationFormData{Type: transmitterType, Error: "Invalid Slack webhook: " + err.Error()})
+	}
+
+	var transmitter = Build(config, true, 0)
+	storeFunction(transmitter.GetStorageValue(id))
+	return renderCreationForm(transmitterCreationFormData{Type: transmitterType, HTMX: template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)})
+}
+
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID     int
+	Config SlackConfig
+	Error  string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: config})
+}
+
+// Replaces the webhook of an existing transmitter.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var data = transmitterEditFormData{ID: stored.Id, Config: configFromForm(ctx)}
+	if err := validateConfig(data.Config); err != nil {
+		data.Error = "Invalid Slack webhook: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	var transmitter = Build(data.Config, stored.Active, stored.TransmitCount)
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+// Attachment color for the priority. Grey for minimal, blue for low, amber for normal and red for high priorities.
+func priorityColor(priority int) string {
+	switch {
+	case priority >= 8:
+		return "#d32f2f"
+	case priority >= 4:
+		return "#f9a825"
+	case priority >= 1:
+		return "#1976d2"
+	}
+	return "#9e9e9e"
+}
+
+// Cuts text down to at most limit characters marking the cut with an ellipsis.
+func truncate(text string, limit int) string {
+	var runes = []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+func buildPayload(msg structs.GotifyMessageStruct, applicationName string) SlackPayload {
+	var blocks = []SlackBlock{}
+	if len(strings.TrimSpace(msg.Title)) > 0 {
+		blocks = append(blocks, SlackBlock{Type: "header", Text: &SlackText{Type: "plain_text", Text: truncate(msg.Title, maxHeaderLength)}})
+	}
+
+	var message = markdown.EscapeMrkdwn(msg.Message)
+	if msg.IsMarkdown() {
+		message = markdown.ToMrkdwn(msg.Message)
+	}
+	if len(strings.TrimSpace(message)) > 0 {
+		blocks = append(blocks, SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: truncate(message, maxSectionLength)}})
+	}
+
+	if len(msg.BigImageURL()) > 0 {
+		// Slack rejects image blocks without alt text.
+		blocks = append(blocks, SlackBlock{Type: "image", ImageURL: msg.BigImageURL(), AltText: cmp.Or(truncate(msg.Title, maxHeaderLength), "Image")})
+	}
+	if len(msg.ClickURL()) > 0 {
+		blocks = append(blocks, SlackBlock{Type: "actions", Elements: []SlackElement{{Type: "button", Text: SlackText{Type: "plain_text", Text: "Open"}, URL: msg.ClickURL()}}})
+	}
+
+	blocks = append(blocks, SlackBlock{Type: "context", Elements: []SlackElement{
+		{Type: "mrkdwn", Text: "*Application:* " + markdown.EscapeMrkdwn(applicationName)},
+		{Type: "mrkdwn", Text: "*Priority:* " + strconv.Itoa(msg.Priority)},
+	}})
+
+	var fallback = msg.Title
+	if len(msg.Message) > 0 {
+		fallback += ": " + markdown.Strip(msg.Message)
+	}
+	return SlackPayload{
+		Text:        markdown.EscapeMrkdwn(truncate(fallback, maxSectionLength)),
+		Attachments: []SlackAttachment{{Color: priorityColor(msg.Priority), Blocks: blocks}},
+	}
+}
+
+func (trans *SlackTransmitter) render(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) ([]byte, error) {
+	var applicationName = fmt.Sprintf("Application %d", msg.Appid)
+	application, err := server.GetApplication(msg.Appid)
+	if err == nil {
+		applicationName = application.Name
+	}
+
+	body, err := json.Marshal(buildPayload(msg, applicationName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Slack payload: %w", err)
+	}
+	return body, nil
+}
+
+// Renders the payload that would be posted for the message without posting it.
+func (trans *SlackTransmitter) Preview(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) (string, error) {
+	body, err := trans.render(msg, server)
+	if err != nil {
+		return "", err
+	}
+	var indented bytes.Buffer
+	json.Indent(&indented, body, "", "  ")
+	return indented.String(), nil
+}
+
+func (trans *SlackTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	body, err := trans.render(msg, server)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", string(trans.config.WebhookURL), bytes.NewReader(body))
+	if err != nil {
+		return errors.New("failed to build Slack webhook request")
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Slack webhook: %w", structs.WithoutURL(err))
+	}
+	defer resp.Body.Close()
+	structs.RecordResponse(ctx, resp.Status)
+
+	// Slack explains failures such as invalid_payload or channel_is_archived in the body.
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &structs.RetryAfterError{
+			RetryAfter: time.Duration(retryAfter) * time.Second,
+			Err:        fmt.Errorf("slack rate limited the webhook: %s", strings.TrimSpace(string(response))),
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack webhook returned response other than 200. Response: %s %s", resp.Status, strings.TrimSpace(string(response)))
+	}
+
+	trans.transmitCount++
+	return nil
+}
+
+//go:embed card.html
+var card string
+
+func (trans SlackTransmitter) HTMLCard(id int) string {
+
+	template, err := template.New("").Parse(card)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+	writer := bytes.Buffer{}
+	type temp struct {
+		WebhookURL string
+		ID         int
+		Status     string
+	}
+	data := temp{ID: id, WebhookURL: string(trans.config.WebhookURL)}
+
+	if trans.Active() {
+		data.Status = "checked"
+	} else {
+		data.Status = ""
+	}
+
+	err = template.Execute(&writer, data)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+
+	return writer.String()
+}
+
+func (trans SlackTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "slack", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
+}
+
+func (trans SlackTransmitter) Active() bool {
+	return trans.status
+}
+
+func (trans *SlackTransmitter) SetStatus(active bool) {
+	trans.status = active
+}
+
+func (trans *SlackTransmitter) GetTransmitCount() int {
+	return trans.transmitCount
+}
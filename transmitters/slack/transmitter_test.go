@@ -0,0 +1,73 @@
+package slackTransmitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransmit(t *testing.T) {
+	var received SlackPayload
+	var path string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte("ok"))
+	}))
+	defer stub.Close()
+
+	trans := Build(SlackConfig{WebhookURL: storage.Secret(stub.URL + "/services/T000/B000/XXXX")}, true, 0)
+	msg := structs.GotifyMessageStruct{Appid: 3, Title: "Backup", Message: "Done in **1.5s** <now>", Priority: 8,
+		Extras: map[string]any{"client::display": map[string]any{"contentType": "text/markdown"}}}
+	err := trans.Transmit(context.Background(), msg, gotify_api.GotifyApi{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/services/T000/B000/XXXX", path)
+	assert.Equal(t, "Backup: Done in 1.5s &lt;now&gt;", received.Text)
+	if assert.Len(t, received.Attachments, 1) {
+		attachment := received.Attachments[0]
+		assert.Equal(t, "#d32f2f", attachment.Color)
+		if assert.Len(t, attachment.Blocks, 3) {
+			assert.Equal(t, "header", attachment.Blocks[0].Type)
+			assert.Equal(t, "Backup", attachment.Blocks[0].Text.Text)
+			assert.Equal(t, "section", attachment.Blocks[1].Type)
+			assert.Equal(t, "mrkdwn", attachment.Blocks[1].Text.Type)
+			assert.Equal(t, "Done in *1.5s* &lt;now&gt;", attachment.Blocks[1].Text.Text)
+			assert.Equal(t, "context", attachment.Blocks[2].Type)
+		}
+	}
+	assert.Equal(t, 1, trans.GetTransmitCount())
+}
+
+func TestTransmitRetryAfter(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte("rate_limited"))
+	}))
+	defer stub.Close()
+
+	trans := Build(SlackConfig{WebhookURL: storage.Secret(stub.URL)}, true, 0)
+	err := trans.Transmit(context.Background(), structs.GotifyMessageStruct{Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	var retryAfter *structs.RetryAfterError
+	assert.True(t, errors.As(err, &retryAfter))
+	assert.Equal(t, 30*time.Second, retryAfter.RetryAfter)
+	assert.Equal(t, 0, trans.GetTransmitCount())
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert.NoError(t, validateConfig(SlackConfig{WebhookURL: "https://hooks.slack.com/services/T0/B0/x"}))
+	assert.NoError(t, validateConfig(SlackConfig{WebhookURL: "http://slack-proxy.lan/services/T0/B0/x"}))
+	assert.EqualError(t, validateConfig(SlackConfig{WebhookURL: "ftp://hooks.slack.com/services/T0/B0/x"}), "the URL must start with http:// or https://")
+	assert.EqualError(t, validateConfig(SlackConfig{WebhookURL: "hooks.slack.com/services"}), "the URL must start with http:// or https://")
+}
@@ -0,0 +1,533 @@
+package smtpTransmitter
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	_ "embed"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/markdown"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+// Ways of securing the connection to the SMTP server.
+const (
+	// Upgrades a plain connection with STARTTLS. Fails if the server does not offer it.
+	SecuritySTARTTLS = "starttls"
+	// Speaks TLS from the start, usually on port 465.
+	SecurityTLS = "tls"
+	// Sends everything in the clear. Credentials are only sent to servers on localhost.
+	SecurityNone = "none"
+)
+
+// Longest time a connection to the server is kept open when the context has no deadline.
+const dialTimeout = 30 * time.Second
+
+type SMTPTransmitter struct {
+	config        SMTPConfig
+	status        bool
+	transmitCount int
+}
+
+// Layout version of SMTPConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for an SMTP transmitter.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Security string
+	// Authentication is skipped when empty.
+	Username string
+	Password storage.Secret
+	From     string
+	To       []string
+}
+
+func Build(config SMTPConfig, status bool, count int) SMTPTransmitter {
+	if len(config.Security) == 0 {
+		config.Security = SecuritySTARTTLS
+	}
+	return SMTPTransmitter{config: config, status: status, transmitCount: count}
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (SMTPConfig, error) {
+	// No transmitters of this type were stored before typed configs existed.
+	return structs.DecodeConfig[SMTPConfig](stored, nil)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*SMTPTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+func configFromForm(ctx *gin.Context) SMTPConfig {
+	port, _ := strconv.Atoi(ctx.PostForm("smtp-port"))
+	var to = []string{}
+	for _, address := range strings.Split(ctx.PostForm("smtp-to"), ",") {
+		if address = strings.TrimSpace(address); len(address) > 0 {
+			to = append(to, address)
+		}
+	}
+	return SMTPConfig{
+		Host:     strings.TrimSpace(ctx.PostForm("smtp-host")),
+		Port:     port,
+		Security: ctx.PostForm("smtp-security"),
+		Username: strings.TrimSpace(ctx.PostForm("smtp-username")),
+		Password: storage.Secret(ctx.PostForm("smtp-password")),
+		From:     strings.TrimSpace(ctx.PostForm("smtp-from")),
+		To:       to,
+	}
+}
+
+func validateConfig(config SMTPConfig) error {
+	if len(config.Host) == 0 {
+		return errors.New("a host is required")
+	}
+	if config.Port < 1 || config.Port > 65535 {
+		return errors.New("the port must be between 1 and 65535")
+	}
+	switch config.Security {
+	case SecuritySTARTTLS, SecurityTLS, SecurityNone:
+	default:
+		return fmt.Errorf("unknown security %q", config.Security)
+	}
+	if _, err := mail.ParseAddress(config.From); err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	if len(config.To) == 0 {
+		return errors.New("at least one recipient is required")
+	}
+	for _, address := range config.To {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// Checks stored settings the same way the forms do before connecting. The server is not contacted.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+//go:embed new.html
+var transmitterCreationForm string
+
+var globalLogger *slog.Logger
+
+func SetGlobalLogger(logger *slog.Logger) {
+	globalLogger = logger
+}
+
+type transmitterCreationFormData struct {
+	Type  string
+	HTMX  template.HTML
+	Error string
+}
+
+func renderCreationForm(data transmitterCreationFormData) []byte {
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func NewTransmitterForm(transmitterType string) []byte {
+	return renderCreationForm(transmitterCreationFormData{Type: transmitterType})
+}
+
+// Creates the transmitter once the server accepts a connection with the credentials.
+func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
+	var transmitter = Build(configFromForm(ctx), true, 0)
+	if err := transmitter.checkServer(ctx.Request.Context()); err != nil {
+		return renderCreationForm(transmitterCreationFormData{Type: transmitterType, Error: "Invalid SMTP settings: " + err.Error()})
+	}
+
+	storeFunction(transmitter.GetStorageValue(id))
+	return renderCreationForm(transmitterCreationFormData{Type: transmitterType, HTMX: template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)})
+}
+
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID     int
+	Config SMTPConfig
+	Error  string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Funcs(template.FuncMap{"join": strings.Join}).Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: transmitter.config})
+}
+
+// Replaces the settings of an existing transmitter. The server must accept a connection with them before they are saved.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var transmitter = Build(configFromForm(ctx), stored.Active, stored.TransmitCount)
+	var data = transmitterEditFormData{ID: stored.Id, Config: transmitter.config}
+	if err := transmitter.checkServer(ctx.Request.Context()); err != nil {
+		data.Error = "Invalid SMTP settings: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+// Opens a connection to the server secured as configured and authenticates if a username is set.
+func (trans *SMTPTransmitter) connect(ctx context.Context) (*smtp.Client, error) {
+	var address = net.JoinHostPort(trans.config.Host, strconv.Itoa(trans.config.Port))
+	var tlsConfig = &tls.Config{ServerName: trans.config.Host}
+	var deadline, hasDeadline = ctx.Deadline()
+	if !hasDeadline {
+		deadline = time.Now().Add(dialTimeout)
+	}
+
+	var dialer = net.Dialer{Deadline: deadline}
+	var conn net.Conn
+	var err error
+	if trans.config.Security == SecurityTLS {
+		conn, err = (&tls.Dialer{NetDialer: &dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// net/smtp has no context support. The deadline keeps a stalled server from holding up the delivery forever.
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, trans.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if trans.config.Security == SecuritySTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("the server does not offer STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	if len(trans.config.Username) > 0 {
+		// PlainAuth refuses to send the password over a connection that is neither encrypted nor to localhost.
+		if err := client.Auth(smtp.PlainAuth("", trans.config.Username, string(trans.config.Password), trans.config.Host)); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// Connects and authenticates without sending anything.
+func (trans *SMTPTransmitter) checkServer(ctx context.Context) error {
+	if err := validateConfig(trans.config); err != nil {
+		return err
+	}
+	client, err := trans.connect(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Domain of the from address. Used for the message IDs.
+func (trans *SMTPTransmitter) domain() string {
+	from, err := mail.ParseAddress(trans.config.From)
+	if err != nil {
+		return trans.config.Host
+	}
+	_, domain, _ := strings.Cut(from.Address, "@")
+	if len(domain) == 0 {
+		return trans.config.Host
+	}
+	return domain
+}
+
+// Message-ID all emails about the application refer to so mail clients show them as one thread.
+// No email carries this ID itself. Clients thread by the references regardless.
+func (trans *SMTPTransmitter) threadID(applicationID int) string {
+	return fmt.Sprintf("<gotify-relay.application-%d@%s>", applicationID, trans.domain())
+}
+
+func (trans *SMTPTransmitter) messageID(msg structs.GotifyMessageStruct) string {
+	if msg.Id == 0 {
+		// Test messages have no ID.
+		return fmt.Sprintf("<gotify-relay.test-%d@%s>", time.Now().UnixNano(), trans.domain())
+	}
+	return fmt.Sprintf("<gotify-relay.message-%d@%s>", msg.Id, trans.domain())
+}
+
+func plainBody(msg structs.GotifyMessageStruct) string {
+	var body = msg.Message
+	if msg.IsMarkdown() {
+		body = markdown.Strip(msg.Message)
+	}
+	if len(msg.ClickURL()) > 0 {
+		body += "\n\n" + msg.ClickURL()
+	}
+	return body
+}
+
+func htmlBody(msg structs.GotifyMessageStruct) string {
+	var message = html.EscapeString(msg.Message)
+	if msg.IsMarkdown() {
+		message = markdown.ToHTML(msg.Message)
+	}
+	var body = strings.Builder{}
+	body.WriteString("<!DOCTYPE html>\n<html><body>\n")
+	if len(msg.Title) > 0 {
+		body.WriteString("<h2>" + html.EscapeString(msg.Title) + "</h2>\n")
+	}
+	// markdown.ToHTML keeps line breaks as newlines.
+	body.WriteString(`<div style="white-space: pre-wrap">` + message + "</div>\n")
+	if len(msg.BigImageURL()) > 0 {
+		body.WriteString(`<p><img src="` + html.EscapeString(msg.BigImageURL()) + `" alt="" style="max-width: 100%"></p>` + "\n")
+	}
+	if len(msg.ClickURL()) > 0 {
+		body.WriteString(`<p><a href="` + html.EscapeString(msg.ClickURL()) + `">Open</a></p>` + "\n")
+	}
+	body.WriteString("</body></html>\n")
+	return body.String()
+}
+
+func writePart(writer *multipart.Writer, contentType string, content string) error {
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	var encoder = quotedprintable.NewWriter(part)
+	if _, err := encoder.Write([]byte(content)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Builds the email as multipart/alternative with a plain text and an HTML part.
+// The subject carries the application name and emails of the same application share a thread.
+func (trans *SMTPTransmitter) buildEmail(msg structs.GotifyMessageStruct, applicationName string) ([]byte, error) {
+	var subject = "[" + applicationName + "]"
+	if len(msg.Title) > 0 {
+		subject += " " + msg.Title
+	}
+
+	var email = bytes.Buffer{}
+	var writer = multipart.NewWriter(&email)
+	var thread = trans.threadID(msg.Appid)
+	var headers = [][2]string{
+		{"From", trans.config.From},
+		{"To", strings.Join(trans.config.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("UTF-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", trans.messageID(msg)},
+		{"In-Reply-To", thread},
+		{"References", thread},
+		{"X-Priority", strconv.Itoa(emailPriority(msg.Priority))},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", `multipart/alternative; boundary="` + writer.Boundary() + `"`},
+	}
+	for _, header := range headers {
+		email.WriteString(header[0] + ": " + header[1] + "\r\n")
+	}
+	email.WriteString("\r\n")
+
+	if err := writePart(writer, "text/plain", plainBody(msg)); err != nil {
+		return nil, err
+	}
+	if err := writePart(writer, "text/html", htmlBody(msg)); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return email.Bytes(), nil
+}
+
+// Maps Gotify's priority onto X-Priority where 1 is the highest and 5 the lowest.
+func emailPriority(priority int) int {
+	switch {
+	case priority >= 8:
+		return 1
+	case priority >= 4:
+		return 3
+	}
+	return 5
+}
+
+func (trans *SMTPTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	var applicationName = fmt.Sprintf("Application %d", msg.Appid)
+	application, err := server.GetApplication(msg.Appid)
+	if err == nil {
+		applicationName = application.Name
+	}
+
+	email, err := trans.buildEmail(msg, applicationName)
+	if err != nil {
+		return fmt.Errorf("failed to build email: %w", err)
+	}
+
+	from, err := mail.ParseAddress(trans.config.From)
+	if err != nil {
+		return fmt.Errorf("invalid from address: %w", err)
+	}
+	client, err := trans.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected the sender: %w", err)
+	}
+	for _, address := range trans.config.To {
+		recipient, err := mail.ParseAddress(address)
+		if err != nil {
+			return fmt.Errorf("invalid recipient %q: %w", address, err)
+		}
+		if err := client.Rcpt(recipient.Address); err != nil {
+			return fmt.Errorf("SMTP server rejected recipient %s: %w", recipient.Address, err)
+		}
+	}
+	data, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	if _, err := data.Write(email); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	// The server accepts or rejects the email once the data is complete.
+	if err := data.Close(); err != nil {
+		var reply *textproto.Error
+		if errors.As(err, &reply) {
+			structs.RecordResponse(ctx, fmt.Sprintf("%d %s", reply.Code, reply.Msg))
+		}
+		return fmt.Errorf("SMTP server rejected the email: %w", err)
+	}
+	structs.RecordResponse(ctx, "250 OK")
+	client.Quit()
+
+	trans.transmitCount++
+	return nil
+}
+
+//go:embed card.html
+var card string
+
+func (trans SMTPTransmitter) HTMLCard(id int) string {
+
+	template, err := template.New("").Parse(card)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+	writer := bytes.Buffer{}
+	type temp struct {
+		Server   string
+		Security string
+		Username string
+		From     string
+		To       string
+		ID       int
+		Status   string
+	}
+	data := temp{ID: id, Server: net.JoinHostPort(trans.config.Host, strconv.Itoa(trans.config.Port)), Security: trans.config.Security,
+		Username: trans.config.Username, From: trans.config.From, To: strings.Join(trans.config.To, ", ")}
+
+	if trans.Active() {
+		data.Status = "checked"
+	} else {
+		data.Status = ""
+	}
+
+	err = template.Execute(&writer, data)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+
+	return writer.String()
+}
+
+func (trans SMTPTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "smtp", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
+}
+
+func (trans SMTPTransmitter) Active() bool {
+	return trans.status
+}
+
+func (trans *SMTPTransmitter) SetStatus(active bool) {
+	trans.status = active
+}
+
+func (trans *SMTPTransmitter) GetTransmitCount() int {
+	return trans.transmitCount
+}
@@ -0,0 +1,187 @@
+package smtpTransmitter
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+// In-process SMTP server speaking just enough of the protocol for net/smtp. Offers AUTH PLAIN but not STARTTLS.
+type smtpStub struct {
+	listener net.Listener
+	// Reply to the end of DATA. Accepts the email when empty.
+	dataReply string
+
+	lock       sync.Mutex
+	auth       string
+	from       string
+	recipients []string
+	emails     []string
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &smtpStub{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return stub
+}
+
+func (stub *smtpStub) port() int {
+	return stub.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (stub *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 stub ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		command, argument, _ := strings.Cut(line, " ")
+		stub.lock.Lock()
+		switch strings.ToUpper(command) {
+		case "EHLO":
+			text.PrintfLine("250-stub\r\n250-AUTH PLAIN\r\n250 8BITMIME")
+		case "AUTH":
+			_, credentials, _ := strings.Cut(argument, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(credentials)
+			stub.auth = string(decoded)
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			stub.from = argument
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			stub.recipients = append(stub.recipients, argument)
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, _ := io.ReadAll(text.DotReader())
+			if len(stub.dataReply) > 0 {
+				text.PrintfLine("%s", stub.dataReply)
+			} else {
+				stub.emails = append(stub.emails, string(data))
+				text.PrintfLine("250 OK")
+			}
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			stub.lock.Unlock()
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+		stub.lock.Unlock()
+	}
+}
+
+func TestTransmit(t *testing.T) {
+	stub := newSMTPStub(t)
+	gotify := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id":3,"name":"Backups"}]`))
+	}))
+	defer gotify.Close()
+
+	trans := Build(SMTPConfig{Host: "127.0.0.1", Port: stub.port(), Security: SecurityNone, Username: "relay", Password: "hunter2",
+		From: "Gotify <gotify@example.org>", To: []string{"ops@example.org", "Phil <phil@example.org>"}}, true, 0)
+	msg := structs.GotifyMessageStruct{Id: 17, Appid: 3, Title: "Nightly", Message: "Done in **1.5s**", Priority: 8,
+		Extras: map[string]any{"client::display": map[string]any{"contentType": "text/markdown"}}}
+	err := trans.Transmit(context.Background(), msg, gotify_api.SetupGotifyApi(gotify.URL, "client-token"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, trans.GetTransmitCount())
+	stub.lock.Lock()
+	defer stub.lock.Unlock()
+	assert.Equal(t, "\x00relay\x00hunter2", stub.auth)
+	assert.Equal(t, "FROM:<gotify@example.org> BODY=8BITMIME", stub.from)
+	assert.Equal(t, []string{"TO:<ops@example.org>", "TO:<phil@example.org>"}, stub.recipients)
+	if !assert.Len(t, stub.emails, 1) {
+		return
+	}
+
+	email, err := mail.ReadMessage(strings.NewReader(stub.emails[0]))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "[Backups] Nightly", email.Header.Get("Subject"))
+	assert.Equal(t, "<gotify-relay.message-17@example.org>", email.Header.Get("Message-ID"))
+	assert.Equal(t, "<gotify-relay.application-3@example.org>", email.Header.Get("In-Reply-To"))
+	assert.Equal(t, "<gotify-relay.application-3@example.org>", email.Header.Get("References"))
+
+	mediaType, params, err := mime.ParseMediaType(email.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	parts := map[string]string{}
+	reader := multipart.NewReader(email.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		content, _ := io.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		parts[contentType] = string(content)
+	}
+	assert.Equal(t, "Done in 1.5s", parts["text/plain"])
+	assert.Contains(t, parts["text/html"], "<h2>Nightly</h2>")
+	assert.Contains(t, parts["text/html"], "Done in <b>1.5s</b>")
+}
+
+func TestTransmitRequiresSTARTTLS(t *testing.T) {
+	stub := newSMTPStub(t)
+
+	trans := Build(SMTPConfig{Host: "127.0.0.1", Port: stub.port(), Security: SecuritySTARTTLS, From: "gotify@example.org", To: []string{"ops@example.org"}}, true, 0)
+	err := trans.Transmit(context.Background(), structs.GotifyMessageStruct{Id: 1, Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	assert.ErrorContains(t, err, "does not offer STARTTLS")
+	stub.lock.Lock()
+	defer stub.lock.Unlock()
+	assert.Empty(t, stub.emails)
+	assert.Equal(t, 0, trans.GetTransmitCount())
+}
+
+func TestTransmitRejected(t *testing.T) {
+	stub := newSMTPStub(t)
+	stub.dataReply = "554 5.7.1 Message rejected as spam"
+
+	trans := Build(SMTPConfig{Host: "127.0.0.1", Port: stub.port(), Security: SecurityNone, From: "gotify@example.org", To: []string{"ops@example.org"}}, true, 0)
+	ctx, recorder := structs.WithResponseRecorder(context.Background())
+	err := trans.Transmit(ctx, structs.GotifyMessageStruct{Id: 1, Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	assert.ErrorContains(t, err, "rejected as spam")
+	assert.Equal(t, "554 5.7.1 Message rejected as spam", recorder.Status)
+	assert.Equal(t, 0, trans.GetTransmitCount())
+}
+
+func TestEmailPriority(t *testing.T) {
+	for priority, expected := range map[int]int{0: 5, 3: 5, 4: 3, 7: 3, 8: 1, 10: 1} {
+		assert.Equal(t, expected, emailPriority(priority), "priority "+strconv.Itoa(priority))
+	}
+}
@@ -0,0 +1,392 @@
+package telegramTransmitter
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/markdown"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+const DefaultAPIURL = "https://api.telegram.org"
+
+type TelegramTransmitter struct {
+	config        TelegramConfig
+	status        bool
+	transmitCount int
+}
+
+// Layout version of TelegramConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for a Telegram transmitter.
+type TelegramConfig struct {
+	BotToken  storage.Secret
+	ChatID    string
+	ParseMode string
+	// Messages with a priority below this are delivered without a notification sound.
+	SilentBelowPriority int
+	APIURL              string
+}
+
+type TelegramPayload struct {
+	ChatID              string                      `json:"chat_id"`
+	Text                string                      `json:"text"`
+	ParseMode           string                      `json:"parse_mode,omitempty"`
+	DisableNotification bool                        `json:"disable_notification"`
+	ReplyMarkup         *TelegramReplyMarkup        `json:"reply_markup,omitempty"`
+	LinkPreviewOptions  *TelegramLinkPreviewOptions `json:"link_preview_options,omitempty"`
+}
+
+type TelegramReplyMarkup struct {
+	InlineKeyboard [][]TelegramInlineButton `json:"inline_keyboard"`
+}
+
+type TelegramInlineButton struct {
+	Text string `json:"text"`
+	Url  string `json:"url"`
+}
+
+type TelegramLinkPreviewOptions struct {
+	Url              string `json:"url"`
+	PreferLargeMedia bool   `json:"prefer_large_media"`
+}
+
+type TelegramResponse struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+func Build(config TelegramConfig, status bool, count int) TelegramTransmitter {
+	if len(config.APIURL) == 0 {
+		config.APIURL = DefaultAPIURL
+	}
+	return TelegramTransmitter{config: config, status: status, transmitCount: count}
+}
+
+// Transmitters stored before typed configs existed kept the same config encoded as JSON in URLorTOKEN.
+func migrateLegacyConfig(urlOrToken string) (TelegramConfig, error) {
+	var config TelegramConfig
+	err := json.Unmarshal([]byte(urlOrToken), &config)
+	return config, err
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (TelegramConfig, error) {
+	return structs.DecodeConfig(stored, migrateLegacyConfig)
+}
+
+// Only checks the settings themselves. checkChat asks Telegram whether the bot can see the chat.
+func validateConfig(config TelegramConfig) error {
+	if len(config.BotToken) == 0 || len(strings.TrimSpace(config.ChatID)) == 0 {
+		return errors.New("a bot token and chat ID are required")
+	}
+	if len(config.APIURL) > 0 && !structs.IsHTTPURL(config.APIURL) {
+		return errors.New("the Bot API URL must start with http:// or https://")
+	}
+	return nil
+}
+
+// Checks stored settings without contacting Telegram.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*TelegramTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+//go:embed new.html
+var transmitterCreationForm string
+
+var globalLogger *slog.Logger
+
+func SetGlobalLogger(logger *slog.Logger) {
+	globalLogger = logger
+}
+
+type transmitterCreationFormData struct {
+	Type string
+	HTMX template.HTML
+}
+
+func NewTransmitterForm(transmitterType string) []byte {
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType})
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
+	var transmitter = Build(configFromForm(ctx), true, 0)
+	storeFunction(transmitter.GetStorageValue(id))
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType, HTMX: template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)})
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func configFromForm(ctx *gin.Context) TelegramConfig {
+	silentPriority, _ := strconv.Atoi(ctx.PostForm("telegram-silent-priority"))
+	return TelegramConfig{
+		BotToken:            storage.Secret(ctx.PostForm("telegram-token")),
+		ChatID:              ctx.PostForm("telegram-chat"),
+		ParseMode:           ctx.PostForm("telegram-parse-mode"),
+		SilentBelowPriority: silentPriority,
+		APIURL:              strings.TrimSuffix(ctx.PostForm("telegram-api-url"), "/"),
+	}
+}
+
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID     int
+	Config TelegramConfig
+	Error  string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: transmitter.config})
+}
+
+// Replaces the settings of an existing transmitter. The bot must be able to see the chat before the settings are saved.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var transmitter = Build(configFromForm(ctx), stored.Active, stored.TransmitCount)
+	var data = transmitterEditFormData{ID: stored.Id, Config: transmitter.config}
+
+	var err = validateConfig(transmitter.config)
+	if err == nil {
+		err = transmitter.checkChat()
+	}
+	if err != nil {
+		data.Error = "Invalid bot token or chat ID: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+// Looks up the chat through the Bot API. Fails if the token is wrong or the bot has no access to the chat.
+func (trans *TelegramTransmitter) checkChat() error {
+	body, err := json.Marshal(map[string]string{"chat_id": trans.config.ChatID})
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post(trans.config.APIURL+"/bot"+string(trans.config.BotToken)+"/getChat", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return structs.WithoutURL(err)
+	}
+	defer resp.Body.Close()
+
+	var response TelegramResponse
+	json.NewDecoder(resp.Body).Decode(&response)
+	if resp.StatusCode != http.StatusOK || !response.Ok {
+		return fmt.Errorf("telegram returned response other than 200. Response: %s %s", resp.Status, response.Description)
+	}
+	return nil
+}
+
+// Characters that must be escaped within MarkdownV2 text.
+var markdownV2Escaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+	">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// Builds the message text along with the parse mode it has to be sent with.
+func (trans *TelegramTransmitter) formatText(msg structs.GotifyMessageStruct) (string, string) {
+	if msg.IsMarkdown() {
+		if len(trans.config.ParseMode) == 0 {
+			return msg.Title + "\n" + markdown.Strip(msg.Message), ""
+		}
+		// Gotify markdown does not follow Telegram's MarkdownV2 rules. Converting to HTML is the reliable option.
+		return "<b>" + html.EscapeString(msg.Title) + "</b>\n" + markdown.ToHTML(msg.Message), "HTML"
+	}
+
+	switch trans.config.ParseMode {
+	case "HTML":
+		return "<b>" + html.EscapeString(msg.Title) + "</b>\n" + html.EscapeString(msg.Message), "HTML"
+	case "MarkdownV2":
+		return "*" + markdownV2Escaper.Replace(msg.Title) + "*\n" + markdownV2Escaper.Replace(msg.Message), "MarkdownV2"
+	}
+	return msg.Title + "\n" + msg.Message, ""
+}
+
+func (trans *TelegramTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	text, parseMode := trans.formatText(msg)
+	var payload = TelegramPayload{
+		ChatID:              trans.config.ChatID,
+		Text:                text,
+		ParseMode:           parseMode,
+		DisableNotification: msg.Priority < trans.config.SilentBelowPriority,
+	}
+	if len(msg.ClickURL()) > 0 {
+		payload.ReplyMarkup = &TelegramReplyMarkup{InlineKeyboard: [][]TelegramInlineButton{{{Text: "Open", Url: msg.ClickURL()}}}}
+	}
+	if len(msg.BigImageURL()) > 0 {
+		payload.LinkPreviewOptions = &TelegramLinkPreviewOptions{Url: msg.BigImageURL(), PreferLargeMedia: true}
+	}
+
+	telegramBytePayload, err := json.Marshal(&payload)
+	if err != nil {
+		return fmt.Errorf("failed to build Telegram payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", trans.config.APIURL+"/bot"+string(trans.config.BotToken)+"/sendMessage", bytes.NewReader(telegramBytePayload))
+	if err != nil {
+		return errors.New("failed to build Telegram request")
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Telegram message: %w", structs.WithoutURL(err))
+	}
+	defer resp.Body.Close()
+	structs.RecordResponse(ctx, resp.Status)
+
+	body, _ := io.ReadAll(resp.Body)
+	var response TelegramResponse
+	json.Unmarshal(body, &response)
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &structs.RetryAfterError{
+			RetryAfter: time.Duration(response.Parameters.RetryAfter) * time.Second,
+			Err:        fmt.Errorf("telegram rate limited the bot: %s", response.Description),
+		}
+	}
+	if resp.StatusCode != http.StatusOK || !response.Ok {
+		return fmt.Errorf("telegram returned response other than 200. Response: %s %s", resp.Status, response.Description)
+	}
+
+	trans.transmitCount++
+	return nil
+}
+
+//go:embed card.html
+var card string
+
+func (trans TelegramTransmitter) HTMLCard(id int) string {
+
+	template, err := template.New("").Parse(card)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+	writer := bytes.Buffer{}
+	type temp struct {
+		ChatID              string
+		Token               string
+		ParseMode           string
+		SilentBelowPriority int
+		APIURL              string
+		ID                  int
+		Status              string
+	}
+	data := temp{ID: id, ChatID: trans.config.ChatID, Token: string(trans.config.BotToken), ParseMode: trans.config.ParseMode, SilentBelowPriority: trans.config.SilentBelowPriority, APIURL: trans.config.APIURL}
+
+	if trans.Active() {
+		data.Status = "checked"
+	} else {
+		data.Status = ""
+	}
+
+	err = template.Execute(&writer, data)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+
+	return writer.String()
+}
+
+func (trans TelegramTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "telegram", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
+}
+
+func (trans TelegramTransmitter) Active() bool {
+	return trans.status
+}
+
+func (trans *TelegramTransmitter) SetStatus(active bool) {
+	trans.status = active
+}
+
+func (trans *TelegramTransmitter) GetTransmitCount() int {
+	return trans.transmitCount
+}
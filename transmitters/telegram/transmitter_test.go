@@ -0,0 +1,52 @@
+package telegramTransmitter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransmit(t *testing.T) {
+	var received TelegramPayload
+	var path string
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&received)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer stub.Close()
+
+	trans := Build(TelegramConfig{BotToken: "123:abc", ChatID: "42", ParseMode: "MarkdownV2", SilentBelowPriority: 5, APIURL: stub.URL}, true, 0)
+	err := trans.Transmit(context.Background(), structs.GotifyMessageStruct{Title: "Backup", Message: "Done in 1.5s!", Priority: 2}, gotify_api.GotifyApi{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "/bot123:abc/sendMessage", path)
+	assert.Equal(t, "42", received.ChatID)
+	assert.Equal(t, "*Backup*\nDone in 1\\.5s\\!", received.Text)
+	assert.True(t, received.DisableNotification)
+	assert.Equal(t, 1, trans.GetTransmitCount())
+}
+
+func TestTransmitRetryAfter(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 30","parameters":{"retry_after":30}}`))
+	}))
+	defer stub.Close()
+
+	trans := Build(TelegramConfig{BotToken: "123:abc", ChatID: "42", APIURL: stub.URL}, true, 0)
+	err := trans.Transmit(context.Background(), structs.GotifyMessageStruct{Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	var retryAfter *structs.RetryAfterError
+	assert.True(t, errors.As(err, &retryAfter))
+	assert.Equal(t, 30*time.Second, retryAfter.RetryAfter)
+	assert.Equal(t, 0, trans.GetTransmitCount())
+}
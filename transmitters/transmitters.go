@@ -1,15 +1,25 @@
 package transmitters
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
 	"github.com/CEKlopfenstein/gotify-repeater/structs"
 	discordTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/discord"
 	discordadvanceTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/discordadvance"
+	gotifyTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/gotify"
 	logTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/log"
+	matrixTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/matrix"
+	ntfyTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/ntfy"
 	pushbulletTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/pushbullet"
+	slackTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/slack"
+	smtpTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/smtp"
+	telegramTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/telegram"
+	webhookTransmitter "github.com/CEKlopfenstein/gotify-repeater/transmitters/webhook"
 	"github.com/gin-gonic/gin"
 )
 
@@ -19,8 +29,10 @@ type Transmitter interface {
 	HTMLCard(int) string
 	// Dehydrates the Transmitter regardless of type into a Struct that can be safely stored for later.
 	GetStorageValue(int) structs.TransmitterStorage
-	// Transmit using this transmitter
-	Transmit(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi)
+	// Transmit using this transmitter. A returned error marks the delivery as failed so it can be retried.
+	// Returns structs.ErrSkipped if the message was deliberately not sent.
+	// The context carries the deadline for the delivery.
+	Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error
 	// Gets a boolean to indicate if it's active
 	Active() bool
 	SetStatus(bool)
@@ -28,12 +40,27 @@ type Transmitter interface {
 	GetTransmitCount() int
 }
 
+// Optionally implemented by transmitters that can show what they would send without sending it.
+type Previewer interface {
+	Preview(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) (string, error)
+}
+
 type TransmitterType struct {
 	Name                string
 	Full_Name           string
 	CreationPage        (func(string) []byte)
 	CreationPostHandler (func(string, *gin.Context, func(transmitter structs.TransmitterStorage) int, int) []byte)
-	SetGlobalLogger     (func(*log.Logger))
+	SetGlobalLogger     (func(*slog.Logger))
+	// Rebuilds a stored transmitter of this type. Fails if its config can not be decoded.
+	Rehydrate (func(structs.TransmitterStorage) (Transmitter, error))
+	// Form for changing the settings of an existing transmitter. Nil for types without settings.
+	EditPage (func(structs.TransmitterStorage) []byte)
+	// Validates the submitted settings and passes them to the update function. Returns the updated card or the form with the problem.
+	EditPutHandler (func(*gin.Context, structs.TransmitterStorage, func(transmitter structs.TransmitterStorage) error) []byte)
+	// Checks the settings of a transmitter created or changed through the API. Nil for types without checks.
+	Validate (func(structs.TransmitterStorage) error)
+	// Empty value of the config stored by this type. Tells which fields are secrets. Nil for types without settings.
+	Config any
 }
 
 var Types = map[string]TransmitterType{
@@ -42,40 +69,166 @@ var Types = map[string]TransmitterType{
 		Full_Name:           "Log Transmitter",
 		CreationPage:        logTransmitter.NewTransmitterForm,
 		CreationPostHandler: logTransmitter.CreateTransmitterFromForm,
-		SetGlobalLogger:     logTransmitter.SetGlobalLogger},
+		SetGlobalLogger:     logTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(logTransmitter.Rehydrate)},
 	"discord": {
 		Name:                "discord",
 		Full_Name:           "Discord Web Hook",
 		CreationPage:        discordTransmitter.NewTransmitterForm,
 		CreationPostHandler: discordTransmitter.CreateTransmitterFromForm,
-		SetGlobalLogger:     discordTransmitter.SetGlobalLogger},
+		SetGlobalLogger:     discordTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(discordTransmitter.Rehydrate),
+		EditPage:            discordTransmitter.EditTransmitterForm,
+		EditPutHandler:      discordTransmitter.UpdateTransmitterFromForm,
+		Validate:            discordTransmitter.Validate,
+		Config:              discordTransmitter.DiscordConfig{}},
 	"pushbullet": {
 		Name:                "pushbullet",
 		Full_Name:           "Pushbullet",
 		CreationPage:        pushbulletTransmitter.NewTransmitterForm,
 		CreationPostHandler: pushbulletTransmitter.CreateTransmitterFromForm,
 		SetGlobalLogger:     pushbulletTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(pushbulletTransmitter.Rehydrate),
+		EditPage:            pushbulletTransmitter.EditTransmitterForm,
+		EditPutHandler:      pushbulletTransmitter.UpdateTransmitterFromForm,
+		Validate:            pushbulletTransmitter.Validate,
+		Config:              pushbulletTransmitter.PushbulletConfig{},
 	}, "discord-advance": {
 		Name:                "discord-advance",
 		Full_Name:           "Discord Embeded Webhook",
 		CreationPage:        discordadvanceTransmitter.NewTransmitterForm,
 		CreationPostHandler: discordadvanceTransmitter.CreateTransmitterFromForm,
 		SetGlobalLogger:     discordadvanceTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(discordadvanceTransmitter.Rehydrate),
+		EditPage:            discordadvanceTransmitter.EditTransmitterForm,
+		EditPutHandler:      discordadvanceTransmitter.UpdateTransmitterFromForm,
+		Validate:            discordadvanceTransmitter.Validate,
+		Config:              discordadvanceTransmitter.DiscordConfig{},
+	}, "telegram": {
+		Name:                "telegram",
+		Full_Name:           "Telegram Bot",
+		CreationPage:        telegramTransmitter.NewTransmitterForm,
+		CreationPostHandler: telegramTransmitter.CreateTransmitterFromForm,
+		SetGlobalLogger:     telegramTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(telegramTransmitter.Rehydrate),
+		EditPage:            telegramTransmitter.EditTransmitterForm,
+		EditPutHandler:      telegramTransmitter.UpdateTransmitterFromForm,
+		Validate:            telegramTransmitter.Validate,
+		Config:              telegramTransmitter.TelegramConfig{},
+	}, "gotify": {
+		Name:                "gotify",
+		Full_Name:           "Secondary Gotify Server",
+		CreationPage:        gotifyTransmitter.NewTransmitterForm,
+		CreationPostHandler: gotifyTransmitter.CreateTransmitterFromForm,
+		SetGlobalLogger:     gotifyTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(gotifyTransmitter.Rehydrate),
+		EditPage:            gotifyTransmitter.EditTransmitterForm,
+		EditPutHandler:      gotifyTransmitter.UpdateTransmitterFromForm,
+		Validate:            gotifyTransmitter.Validate,
+		Config:              gotifyTransmitter.GotifyConfig{},
+	}, "webhook": {
+		Name:                "webhook",
+		Full_Name:           "Generic Webhook",
+		CreationPage:        webhookTransmitter.NewTransmitterForm,
+		CreationPostHandler: webhookTransmitter.CreateTransmitterFromForm,
+		SetGlobalLogger:     webhookTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(webhookTransmitter.Rehydrate),
+		EditPage:            webhookTransmitter.EditTransmitterForm,
+		EditPutHandler:      webhookTransmitter.UpdateTransmitterFromForm,
+		Validate:            webhookTransmitter.Validate,
+		Config:              webhookTransmitter.WebhookConfig{},
+	}, "slack": {
+		Name:                "slack",
+		Full_Name:           "Slack Web Hook",
+		CreationPage:        slackTransmitter.NewTransmitterForm,
+		CreationPostHandler: slackTransmitter.CreateTransmitterFromForm,
+		SetGlobalLogger:     slackTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(slackTransmitter.Rehydrate),
+		EditPage:            slackTransmitter.EditTransmitterForm,
+		EditPutHandler:      slackTransmitter.UpdateTransmitterFromForm,
+		Validate:            slackTransmitter.Validate,
+		Config:              slackTransmitter.SlackConfig{},
+	}, "matrix": {
+		Name:                "matrix",
+		Full_Name:           "Matrix Room",
+		CreationPage:        matrixTransmitter.NewTransmitterForm,
+		CreationPostHandler: matrixTransmitter.CreateTransmitterFromForm,
+		SetGlobalLogger:     matrixTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(matrixTransmitter.Rehydrate),
+		EditPage:            matrixTransmitter.EditTransmitterForm,
+		EditPutHandler:      matrixTransmitter.UpdateTransmitterFromForm,
+		Validate:            matrixTransmitter.Validate,
+		Config:              matrixTransmitter.MatrixConfig{},
+	}, "ntfy": {
+		Name:                "ntfy",
+		Full_Name:           "ntfy Topic",
+		CreationPage:        ntfyTransmitter.NewTransmitterForm,
+		CreationPostHandler: ntfyTransmitter.CreateTransmitterFromForm,
+		SetGlobalLogger:     ntfyTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(ntfyTransmitter.Rehydrate),
+		EditPage:            ntfyTransmitter.EditTransmitterForm,
+		EditPutHandler:      ntfyTransmitter.UpdateTransmitterFromForm,
+		Validate:            ntfyTransmitter.Validate,
+		Config:              ntfyTransmitter.NtfyConfig{},
+	}, "smtp": {
+		Name:                "smtp",
+		Full_Name:           "Email (SMTP)",
+		CreationPage:        smtpTransmitter.NewTransmitterForm,
+		CreationPostHandler: smtpTransmitter.CreateTransmitterFromForm,
+		SetGlobalLogger:     smtpTransmitter.SetGlobalLogger,
+		Rehydrate:           rehydrator(smtpTransmitter.Rehydrate),
+		EditPage:            smtpTransmitter.EditTransmitterForm,
+		EditPutHandler:      smtpTransmitter.UpdateTransmitterFromForm,
+		Validate:            smtpTransmitter.Validate,
+		Config:              smtpTransmitter.SMTPConfig{},
 	}}
 
-func RehydrateTransmitter(stored structs.TransmitterStorage) Transmitter {
-	if stored.TransmitterType == "discord" {
-		trans := discordTransmitter.Build(stored.URLorTOKEN, fmt.Sprintf("Transmitter %d", stored.Id), stored.Active, stored.TransmitCount)
-		return &trans
-	} else if stored.TransmitterType == "log" {
-		trans := logTransmitter.Build(stored.Active, stored.TransmitCount)
-		return &trans
-	} else if stored.TransmitterType == "pushbullet" {
-		trans := pushbulletTransmitter.Build(stored.URLorTOKEN, fmt.Sprintf("Transmitter %d", stored.Id), stored.Active, stored.TransmitCount)
-		return &trans
-	} else if stored.TransmitterType == "discord-advance" {
-		trans := discordadvanceTransmitter.Build(stored.URLorTOKEN, fmt.Sprintf("Transmitter %d", stored.Id), stored.Active, stored.TransmitCount)
-		return &trans
+// Adapts the Rehydrate function of a transmitter package to return the Transmitter interface.
+func rehydrator[T Transmitter](rehydrate func(structs.TransmitterStorage) (T, error)) func(structs.TransmitterStorage) (Transmitter, error) {
+	return func(stored structs.TransmitterStorage) (Transmitter, error) {
+		transmitter, err := rehydrate(stored)
+		if err != nil {
+			return nil, err
+		}
+		return transmitter, nil
+	}
+}
+
+// Rebuilds a stored transmitter. Its config is decoded into the config struct of its package.
+// Transmitters stored before typed configs existed are migrated. GetStorageValue returns the migrated form.
+func RehydrateTransmitter(stored structs.TransmitterStorage) (Transmitter, error) {
+	var transmitterType, found = Types[stored.TransmitterType]
+	if !found || transmitterType.Rehydrate == nil {
+		return nil, fmt.Errorf("unknown transmitter type %q", stored.TransmitterType)
+	}
+	transmitter, err := transmitterType.Rehydrate(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config of %s transmitter %d: %w", stored.TransmitterType, stored.Id, err)
+	}
+	return transmitter, nil
+}
+
+// Checks the settings of a stored transmitter with the checks of its type. Types without checks only need a config
+// that can be decoded.
+func ValidateTransmitter(stored structs.TransmitterStorage) error {
+	var transmitterType, found = Types[stored.TransmitterType]
+	if !found {
+		return fmt.Errorf("unknown transmitter type %q", stored.TransmitterType)
+	}
+	if transmitterType.Validate == nil {
+		return nil
+	}
+	if err := transmitterType.Validate(stored); err != nil {
+		return fmt.Errorf("invalid %s settings: %w", stored.TransmitterType, err)
+	}
+	return nil
+}
+
+// Returns the config of a stored transmitter with its secrets decrypted. With redact every secret of its type is
+// replaced by storage.RedactedSecret instead, whether it is stored encrypted or not.
+func ShowConfig(stored structs.TransmitterStorage, redact bool) (json.RawMessage, error) {
+	if !redact {
+		return storage.RevealSecrets(stored.Config)
 	}
-	return &logTransmitter.LogTransmittor{}
+	return storage.RedactSecrets(stored.Config, Types[stored.TransmitterType].Config)
 }
@@ -0,0 +1,379 @@
+package webhookTransmitter
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/markdown"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+const DefaultBodyTemplate = `{"title": {{json .Message.Title}}, "message": {{json .Message.Message}}, "priority": {{.Message.Priority}}, "application": {{json .Application.Name}}}`
+
+type WebhookTransmitter struct {
+	config        WebhookConfig
+	body          *texttemplate.Template
+	templateError error
+	status        bool
+	transmitCount int
+}
+
+// Layout version of WebhookConfig written to structs.TransmitterStorage.ConfigVersion.
+const ConfigVersion = 1
+
+// Settings stored for a Webhook transmitter.
+type WebhookConfig struct {
+	URL    storage.Secret
+	Method string
+	// Values often hold credentials such as Authorization headers.
+	Headers      map[string]storage.Secret
+	ContentType  string
+	BodyTemplate string
+}
+
+// Data the body template is rendered against.
+type TemplateData struct {
+	Message     structs.GotifyMessageStruct
+	Application gotify_api.GotifyApplication
+}
+
+var templateFunctions = texttemplate.FuncMap{
+	// Encodes the value as JSON. Useful for safely placing strings within JSON bodies.
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"markdownToHTML": markdown.ToHTML,
+	"stripMarkdown":  markdown.Strip,
+}
+
+// Parses a body template the same way the transmitter will when sending.
+func ParseBodyTemplate(body string) (*texttemplate.Template, error) {
+	return texttemplate.New("body").Funcs(templateFunctions).Parse(body)
+}
+
+func Build(config WebhookConfig, status bool, count int) WebhookTransmitter {
+	if len(config.Method) == 0 {
+		config.Method = http.MethodPost
+	}
+	if len(config.ContentType) == 0 {
+		config.ContentType = "application/json"
+	}
+	var transmitter = WebhookTransmitter{config: config, status: status, transmitCount: count}
+	transmitter.body, transmitter.templateError = ParseBodyTemplate(config.BodyTemplate)
+	return transmitter
+}
+
+// Transmitters stored before typed configs existed kept the same config encoded as JSON in URLorTOKEN.
+func migrateLegacyConfig(urlOrToken string) (WebhookConfig, error) {
+	var config WebhookConfig
+	err := json.Unmarshal([]byte(urlOrToken), &config)
+	return config, err
+}
+
+func decodeConfig(stored structs.TransmitterStorage) (WebhookConfig, error) {
+	return structs.DecodeConfig(stored, migrateLegacyConfig)
+}
+
+func Rehydrate(stored structs.TransmitterStorage) (*WebhookTransmitter, error) {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return nil, err
+	}
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return &transmitter, nil
+}
+
+// Checks the URL and body template. The webhook is not called as that would deliver a message.
+func validateConfig(config WebhookConfig) error {
+	parsedURL, err := url.ParseRequestURI(string(config.URL))
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || len(parsedURL.Host) == 0 {
+		return errors.New("the URL must be an absolute http or https URL")
+	}
+	if _, err := ParseBodyTemplate(config.BodyTemplate); err != nil {
+		return fmt.Errorf("invalid body template: %w", err)
+	}
+	return nil
+}
+
+// Checks stored settings the same way the forms do.
+func Validate(stored structs.TransmitterStorage) error {
+	config, err := decodeConfig(stored)
+	if err != nil {
+		return err
+	}
+	return validateConfig(config)
+}
+
+// Reads "Name: Value" pairs, one per line.
+func ParseHeaders(headers string) map[string]storage.Secret {
+	var parsed = map[string]storage.Secret{}
+	for _, line := range strings.Split(headers, "\n") {
+		name, value, found := strings.Cut(line, ":")
+		if !found || len(strings.TrimSpace(name)) == 0 {
+			continue
+		}
+		parsed[strings.TrimSpace(name)] = storage.Secret(strings.TrimSpace(value))
+	}
+	return parsed
+}
+
+//go:embed new.html
+var transmitterCreationForm string
+
+var globalLogger *slog.Logger
+
+func SetGlobalLogger(logger *slog.Logger) {
+	globalLogger = logger
+}
+
+type transmitterCreationFormData struct {
+	Type            string
+	HTMX            template.HTML
+	DefaultTemplate string
+	Error           string
+}
+
+func NewTransmitterForm(transmitterType string) []byte {
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, transmitterCreationFormData{Type: transmitterType, DefaultTemplate: DefaultBodyTemplate})
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func CreateTransmitterFromForm(transmitterType string, ctx *gin.Context, storeFunction func(transmitter structs.TransmitterStorage) int, id int) []byte {
+	var transmitter = Build(configFromForm(ctx), true, 0)
+
+	var data = transmitterCreationFormData{Type: transmitterType, DefaultTemplate: DefaultBodyTemplate}
+	if err := validateConfig(transmitter.config); err != nil {
+		data.DefaultTemplate = transmitter.config.BodyTemplate
+		data.Error = "Invalid webhook settings: " + err.Error()
+	} else {
+		storeFunction(transmitter.GetStorageValue(id))
+		data.HTMX = template.HTML(`<span hx-swap="beforebegin" hx-target="closest #newTransmitters" hx-get="transmitter/` + fmt.Sprint(id) + `" hx-trigger="load once"></span>`)
+	}
+
+	templ, err := template.New("").Parse(transmitterCreationForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func configFromForm(ctx *gin.Context) WebhookConfig {
+	return WebhookConfig{
+		URL:          storage.Secret(ctx.PostForm("webhook-url")),
+		Method:       strings.ToUpper(ctx.PostForm("webhook-method")),
+		Headers:      ParseHeaders(ctx.PostForm("webhook-headers")),
+		ContentType:  ctx.PostForm("webhook-content-type"),
+		BodyTemplate: ctx.PostForm("webhook-template"),
+	}
+}
+
+// Writes headers back into the "Name: Value" per line format read by ParseHeaders.
+func FormatHeaders(headers map[string]storage.Secret) string {
+	var lines = make([]string, 0, len(headers))
+	for _, name := range slices.Sorted(maps.Keys(headers)) {
+		lines = append(lines, name+": "+string(headers[name]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//go:embed edit.html
+var transmitterEditForm string
+
+type transmitterEditFormData struct {
+	ID      int
+	Config  WebhookConfig
+	Headers string
+	Error   string
+}
+
+func renderEditForm(data transmitterEditFormData) []byte {
+	templ, err := template.New("").Parse(transmitterEditForm)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	var buffer = bytes.Buffer{}
+
+	err = templ.Execute(&buffer, data)
+
+	if err != nil {
+		globalLogger.Error(err.Error())
+	}
+
+	return buffer.Bytes()
+}
+
+func EditTransmitterForm(stored structs.TransmitterStorage) []byte {
+	config, _ := decodeConfig(stored)
+	var transmitter = Build(config, stored.Active, stored.TransmitCount)
+	return renderEditForm(transmitterEditFormData{ID: stored.Id, Config: transmitter.config, Headers: FormatHeaders(transmitter.config.Headers)})
+}
+
+// Replaces the settings of an existing transmitter. The URL and body template are checked before they are saved.
+func UpdateTransmitterFromForm(ctx *gin.Context, stored structs.TransmitterStorage, updateFunction func(transmitter structs.TransmitterStorage) error) []byte {
+	var transmitter = Build(configFromForm(ctx), stored.Active, stored.TransmitCount)
+	var data = transmitterEditFormData{ID: stored.Id, Config: transmitter.config, Headers: ctx.PostForm("webhook-headers")}
+
+	if err := validateConfig(transmitter.config); err != nil {
+		data.Error = "Invalid webhook settings: " + err.Error()
+		return renderEditForm(data)
+	}
+
+	if err := updateFunction(transmitter.GetStorageValue(stored.Id)); err != nil {
+		data.Error = err.Error()
+		return renderEditForm(data)
+	}
+	return []byte(transmitter.HTMLCard(stored.Id))
+}
+
+func (trans *WebhookTransmitter) render(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) ([]byte, error) {
+	if trans.templateError != nil {
+		return nil, fmt.Errorf("invalid body template: %w", trans.templateError)
+	}
+
+	var data = TemplateData{Message: msg}
+	application, err := server.GetApplication(msg.Appid)
+	if err == nil {
+		data.Application = application
+	}
+
+	var buffer = bytes.Buffer{}
+	err = trans.body.Execute(&buffer, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render body template: %w", err)
+	}
+	return buffer.Bytes(), nil
+}
+
+// Renders the body that would be sent for the message without sending it.
+func (trans *WebhookTransmitter) Preview(msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) (string, error) {
+	body, err := trans.render(msg, server)
+	return string(body), err
+}
+
+func (trans *WebhookTransmitter) Transmit(ctx context.Context, msg structs.GotifyMessageStruct, server gotify_api.GotifyApi) error {
+	body, err := trans.render(msg, server)
+	if err != nil {
+		return err
+	}
+
+	client := http.Client{}
+	req, err := http.NewRequestWithContext(ctx, trans.config.Method, string(trans.config.URL), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build Webhook request: %w", structs.WithoutURL(err))
+	}
+
+	req.Header.Set("Content-Type", trans.config.ContentType)
+	for name, value := range trans.config.Headers {
+		req.Header.Set(name, string(value))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send Webhook: %w", structs.WithoutURL(err))
+	}
+	defer resp.Body.Close()
+	structs.RecordResponse(ctx, resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned non 2xx response. Response: %s %s", resp.Status, responseBody)
+	}
+	trans.transmitCount++
+	return nil
+}
+
+//go:embed card.html
+var card string
+
+func (trans WebhookTransmitter) HTMLCard(id int) string {
+
+	template, err := template.New("").Parse(card)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+	writer := bytes.Buffer{}
+	type temp struct {
+		URL          string
+		Method       string
+		ContentType  string
+		Headers      map[string]storage.Secret
+		BodyTemplate string
+		ID           int
+		Status       string
+	}
+	data := temp{ID: id, URL: string(trans.config.URL), Method: trans.config.Method, ContentType: trans.config.ContentType, Headers: trans.config.Headers, BodyTemplate: trans.config.BodyTemplate}
+
+	if trans.Active() {
+		data.Status = "checked"
+	} else {
+		data.Status = ""
+	}
+
+	err = template.Execute(&writer, data)
+	if err != nil {
+		globalLogger.Error(err.Error())
+		return err.Error()
+	}
+
+	return writer.String()
+}
+
+func (trans WebhookTransmitter) GetStorageValue(id int) structs.TransmitterStorage {
+	var stored = structs.TransmitterStorage{Id: id, TransmitterType: "webhook", Active: trans.Active(), TransmitCount: trans.GetTransmitCount()}
+	stored.SetConfig(trans.config, ConfigVersion)
+	return stored
+}
+
+func (trans WebhookTransmitter) Active() bool {
+	return trans.status
+}
+
+func (trans *WebhookTransmitter) SetStatus(active bool) {
+	trans.status = active
+}
+
+func (trans *WebhookTransmitter) GetTransmitCount() int {
+	return trans.transmitCount
+}
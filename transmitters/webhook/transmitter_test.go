@@ -0,0 +1,77 @@
+package webhookTransmitter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransmitErrorHidesURL(t *testing.T) {
+	// Nothing listens on the port once the listener is closed.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	trans := Build(WebhookConfig{URL: storage.Secret("http://" + listener.Addr().String() + "/hooks/secret-token")}, true, 0)
+	err = trans.Transmit(context.Background(), structs.GotifyMessageStruct{Title: "Backup", Message: "Done"}, gotify_api.GotifyApi{})
+
+	assert.ErrorContains(t, err, "failed to send Webhook")
+	assert.NotContains(t, err.Error(), "secret-token")
+}
+
+func TestValidateConfig(t *testing.T) {
+	var tests = []struct {
+		name   string
+		config WebhookConfig
+		err    string
+	}{
+		{"https", WebhookConfig{URL: "https://example.org/hook"}, ""},
+		{"http", WebhookConfig{URL: "http://192.168.1.5:8080/hook"}, ""},
+		{"relative", WebhookConfig{URL: "/hook"}, "absolute http or https URL"},
+		{"other scheme", WebhookConfig{URL: "ftp://example.org/hook"}, "absolute http or https URL"},
+		{"missing host", WebhookConfig{URL: "https:///hook"}, "absolute http or https URL"},
+		{"invalid template", WebhookConfig{URL: "https://example.org/hook", BodyTemplate: "{{.Message"}, "invalid body template"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateConfig(test.config)
+			if len(test.err) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestCreateRejectsInvalidURL(t *testing.T) {
+	SetGlobalLogger(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	gin.SetMode(gin.TestMode)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	var form = url.Values{"webhook-url": {"ftp://example.org/hook"}, "webhook-template": {DefaultBodyTemplate}}
+	ctx.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	ctx.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var stored = false
+	var page = CreateTransmitterFromForm("webhook", ctx, func(transmitter structs.TransmitterStorage) int {
+		stored = true
+		return 1
+	}, 1)
+
+	assert.False(t, stored)
+	assert.Contains(t, string(page), "Invalid webhook settings")
+}
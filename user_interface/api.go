@@ -0,0 +1,288 @@
+package user_interface
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/CEKlopfenstein/gotify-repeater/filters"
+	"github.com/CEKlopfenstein/gotify-repeater/relay"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/CEKlopfenstein/gotify-repeater/transmitters"
+	"github.com/gin-gonic/gin"
+)
+
+// Error body returned by the API. Matches the errors returned by Gotify's own API.
+type apiError struct {
+	Error            string `json:"error"`
+	ErrorCode        int    `json:"errorCode"`
+	ErrorDescription string `json:"errorDescription"`
+}
+
+func rejectAPI(ctx *gin.Context, status int, message string) {
+	ctx.AbortWithStatusJSON(status, apiError{Error: http.StatusText(status), ErrorCode: status, ErrorDescription: message})
+}
+
+// A transmitter as returned by the API. Secrets within Config are redacted unless asked for.
+type apiTransmitter struct {
+	Id            int
+	Type          string
+	Active        bool
+	TransmitCount int
+	ConfigVersion int
+	Config        json.RawMessage
+	Filters       structs.TransmitterFilters
+}
+
+// Body of POST /api/v1/transmitters.
+type apiTransmitterCreate struct {
+	Type string
+	// Defaults to true.
+	Active  *bool
+	Config  json.RawMessage
+	Filters structs.TransmitterFilters
+}
+
+// Body of PUT /api/v1/transmitters/:transmitterID. Only the fields given are changed.
+// Redacted secrets within Config keep their current value.
+type apiTransmitterUpdate struct {
+	Active  *bool
+	Config  json.RawMessage
+	Filters *structs.TransmitterFilters
+}
+
+type apiStatus struct {
+	Active bool
+}
+
+type apiCount struct {
+	TransmitCount int
+}
+
+type apiToken struct {
+	Token string
+}
+
+type apiTransmitterType struct {
+	Name     string
+	FullName string
+}
+
+type apiRelayState struct {
+	relay.StreamStatus
+	Description string
+}
+
+func toAPITransmitter(id int, transmitter transmitters.Transmitter, transmitterFilters structs.TransmitterFilters, reveal bool) (apiTransmitter, error) {
+	var stored = transmitter.GetStorageValue(id)
+	config, err := transmitters.ShowConfig(stored, !reveal)
+	if err != nil {
+		return apiTransmitter{}, err
+	}
+	return apiTransmitter{
+		Id:            id,
+		Type:          stored.TransmitterType,
+		Active:        stored.Active,
+		TransmitCount: stored.TransmitCount,
+		ConfigVersion: stored.ConfigVersion,
+		Config:        config,
+		Filters:       transmitterFilters,
+	}, nil
+}
+
+// JSON API for managing the relay from scripts. Mirrors what the HTML endpoints offer.
+// Secrets are redacted from returned transmitters unless secrets=true is given.
+func buildAPIRoutes(api *gin.RouterGroup, relay *relay.Relay, c *storage.Storage, logger *slog.Logger) {
+	var respondTransmitter = func(ctx *gin.Context, status int, id int) {
+		var transmitter = relay.GetTransmitters()[id]
+		if transmitter == nil {
+			rejectAPI(ctx, http.StatusNotFound, fmt.Sprintf("transmitter %d not found", id))
+			return
+		}
+		result, err := toAPITransmitter(id, transmitter, relay.GetTransmitterFilters(id), ctx.Query("secrets") == "true")
+		if err != nil {
+			logger.Error(err.Error())
+			rejectAPI(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(status, result)
+	}
+
+	api.GET("/transmitter-types", func(ctx *gin.Context) {
+		var types = []apiTransmitterType{}
+		for _, name := range slices.Sorted(maps.Keys(transmitters.Types)) {
+			types = append(types, apiTransmitterType{Name: name, FullName: transmitters.Types[name].Full_Name})
+		}
+		ctx.JSON(http.StatusOK, types)
+	})
+
+	api.GET("/transmitters", func(ctx *gin.Context) {
+		var current = relay.GetTransmitters()
+		var result = []apiTransmitter{}
+		for _, id := range slices.Sorted(maps.Keys(current)) {
+			transmitter, err := toAPITransmitter(id, current[id], relay.GetTransmitterFilters(id), ctx.Query("secrets") == "true")
+			if err != nil {
+				logger.Error(err.Error())
+				rejectAPI(ctx, http.StatusInternalServerError, err.Error())
+				return
+			}
+			result = append(result, transmitter)
+		}
+		ctx.JSON(http.StatusOK, result)
+	})
+
+	api.POST("/transmitters", func(ctx *gin.Context) {
+		var request apiTransmitterCreate
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			rejectAPI(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, found := transmitters.Types[request.Type]; !found {
+			rejectAPI(ctx, http.StatusBadRequest, fmt.Sprintf("unknown transmitter type %q", request.Type))
+			return
+		}
+		if _, err := filters.Build(request.Filters); err != nil {
+			rejectAPI(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var stored = structs.TransmitterStorage{TransmitterType: request.Type, Active: request.Active == nil || *request.Active, Config: request.Config}
+		// Checked before rehydrating as some types contact their service when they are built.
+		var err = transmitters.ValidateTransmitter(stored)
+		var transmitter transmitters.Transmitter
+		if err == nil {
+			transmitter, err = transmitters.RehydrateTransmitter(stored)
+		}
+		if err != nil {
+			rejectAPI(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		var id = relay.AddTransmitter(transmitter)
+		if err := relay.SetTransmitterFilters(id, request.Filters); err != nil {
+			rejectAPI(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		respondTransmitter(ctx, http.StatusCreated, id)
+	})
+
+	transmitterGroup := api.Group("/transmitters/:transmitterID", func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("transmitterID"))
+		if err != nil {
+			rejectAPI(ctx, http.StatusBadRequest, "invalid transmitter ID")
+			return
+		}
+		var transmitter = relay.GetTransmitters()[id]
+		if transmitter == nil {
+			rejectAPI(ctx, http.StatusNotFound, fmt.Sprintf("transmitter %d not found", id))
+			return
+		}
+		ctx.Set("transID", id)
+		ctx.Set("transmitter", transmitter)
+		ctx.Next()
+	})
+
+	transmitterGroup.GET("", func(ctx *gin.Context) {
+		respondTransmitter(ctx, http.StatusOK, ctx.GetInt("transID"))
+	})
+
+	transmitterGroup.PUT("", func(ctx *gin.Context) {
+		var id = ctx.GetInt("transID")
+		var request apiTransmitterUpdate
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			rejectAPI(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		// Checked first so an invalid request changes nothing.
+		if request.Filters != nil {
+			if _, err := filters.Build(*request.Filters); err != nil {
+				rejectAPI(ctx, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+
+		if len(request.Config) > 0 {
+			var existing = ctx.MustGet("transmitter").(transmitters.Transmitter).GetStorageValue(id)
+			config, _, err := storage.FillRedactedSecrets(request.Config, existing.Config)
+			var updated = structs.TransmitterStorage{TransmitterType: existing.TransmitterType, Config: config}
+			if err == nil {
+				err = transmitters.ValidateTransmitter(updated)
+			}
+			if err == nil {
+				err = relay.UpdateTransmitter(id, updated)
+			}
+			if err != nil {
+				rejectAPI(ctx, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if request.Filters != nil {
+			relay.SetTransmitterFilters(id, *request.Filters)
+		}
+		if request.Active != nil {
+			relay.SetTransmitterStatus(id, *request.Active)
+		}
+		respondTransmitter(ctx, http.StatusOK, id)
+	})
+
+	transmitterGroup.DELETE("", func(ctx *gin.Context) {
+		relay.RemoveTransmitter(ctx.GetInt("transID"))
+		ctx.Status(http.StatusNoContent)
+	})
+
+	transmitterGroup.GET("/status", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, apiStatus{Active: ctx.MustGet("transmitter").(transmitters.Transmitter).Active()})
+	})
+
+	transmitterGroup.PUT("/status", func(ctx *gin.Context) {
+		var request apiStatus
+		if err := ctx.ShouldBindJSON(&request); err != nil {
+			rejectAPI(ctx, http.StatusBadRequest, err.Error())
+			return
+		}
+		relay.SetTransmitterStatus(ctx.GetInt("transID"), request.Active)
+		ctx.JSON(http.StatusOK, apiStatus{Active: ctx.MustGet("transmitter").(transmitters.Transmitter).Active()})
+	})
+
+	transmitterGroup.GET("/count", func(ctx *gin.Context) {
+		var transmitCount = ctx.MustGet("transmitter").(transmitters.Transmitter).GetTransmitCount()
+		if transmitCount == -1 {
+			rejectAPI(ctx, http.StatusNotImplemented, "Selected transmitter does not implement transmition count.")
+			return
+		}
+		ctx.JSON(http.StatusOK, apiCount{TransmitCount: transmitCount})
+	})
+
+	api.GET("/token", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, apiToken{Token: c.GetClientToken()})
+	})
+
+	// Sets the client token the relay listens with. The token must be valid.
+	api.PUT("/token", func(ctx *gin.Context) {
+		var request apiToken
+		if err := ctx.ShouldBindJSON(&request); err != nil || len(request.Token) == 0 {
+			rejectAPI(ctx, http.StatusBadRequest, "Token missing")
+			return
+		}
+		var server = relay.GetGotifyApi()
+		if err := server.CheckToken(request.Token); err != nil {
+			rejectAPI(ctx, http.StatusBadRequest, "invalid token: "+err.Error())
+			return
+		}
+		if err := relay.UpdateToken(request.Token); err != nil {
+			logger.Error(err.Error())
+			rejectAPI(ctx, http.StatusInternalServerError, err.Error())
+			return
+		}
+		ctx.JSON(http.StatusOK, apiToken{Token: c.GetClientToken()})
+	})
+
+	api.GET("/relay-state", func(ctx *gin.Context) {
+		var status = relay.GetStreamStatus()
+		ctx.JSON(http.StatusOK, apiRelayState{StreamStatus: status, Description: status.String()})
+	})
+}
@@ -0,0 +1,156 @@
+package user_interface
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/CEKlopfenstein/gotify-repeater/relay"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Keeps the stored data of the relay in memory.
+type memoryStorageHandler struct {
+	lock sync.Mutex
+	data []byte
+}
+
+func (handler *memoryStorageHandler) Save(data []byte) error {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	handler.data = data
+	return nil
+}
+
+func (handler *memoryStorageHandler) Load() ([]byte, error) {
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+	return handler.data, nil
+}
+
+// API routes without authentication backed by an empty relay.
+func newAPITest(t *testing.T) (*gin.Engine, *relay.Relay) {
+	t.Setenv(storage.SecretKeyEnv, "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	gin.SetMode(gin.TestMode)
+	var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	var stored = &storage.Storage{StorageHandler: &memoryStorageHandler{}, Logger: logger}
+	var relay relay.Relay
+	relay.SetLogger(logger)
+	relay.SetStorage(stored)
+
+	var engine = gin.New()
+	buildAPIRoutes(engine.Group("/api/v1"), &relay, stored, logger)
+	return engine, &relay
+}
+
+func requestAPI(engine *gin.Engine, method string, path string, body any) (*httptest.ResponseRecorder, apiError) {
+	encoded, _ := json.Marshal(body)
+	var recorder = httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(method, path, bytes.NewReader(encoded)))
+	var rejected apiError
+	json.Unmarshal(recorder.Body.Bytes(), &rejected)
+	return recorder, rejected
+}
+
+func TestCreateTransmitterValidatesConfig(t *testing.T) {
+	var tests = []struct {
+		name     string
+		body     apiTransmitterCreate
+		status   int
+		rejected string
+	}{
+		{"valid ntfy", apiTransmitterCreate{Type: "ntfy", Config: json.RawMessage(`{"ServerURL":"https://ntfy.sh","Topic":"alerts"}`)}, http.StatusCreated, ""},
+		{"ntfy without topic", apiTransmitterCreate{Type: "ntfy", Config: json.RawMessage(`{"ServerURL":"https://ntfy.sh","Topic":""}`)}, http.StatusBadRequest, "topic"},
+		{"slack with ftp URL", apiTransmitterCreate{Type: "slack", Config: json.RawMessage(`{"WebhookURL":"ftp://hooks.slack.com/services/x"}`)}, http.StatusBadRequest, "invalid slack settings"},
+		{"smtp without recipients", apiTransmitterCreate{Type: "smtp", Config: json.RawMessage(`{"Host":"mail.example.org","Port":587,"Security":"starttls","From":"relay@example.org"}`)}, http.StatusBadRequest, "recipient"},
+		{"unknown type", apiTransmitterCreate{Type: "pager"}, http.StatusBadRequest, "unknown transmitter type"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine, relay := newAPITest(t)
+			recorder, rejected := requestAPI(engine, http.MethodPost, "/api/v1/transmitters", test.body)
+			assert.Equal(t, test.status, recorder.Code, recorder.Body.String())
+			if test.status == http.StatusCreated {
+				assert.Len(t, relay.GetTransmitters(), 1)
+				return
+			}
+			assert.Equal(t, test.status, rejected.ErrorCode)
+			assert.Contains(t, rejected.ErrorDescription, test.rejected)
+			assert.Empty(t, relay.GetTransmitters())
+		})
+	}
+}
+
+func TestUpdateTransmitterValidatesConfig(t *testing.T) {
+	engine, relay := newAPITest(t)
+	recorder, _ := requestAPI(engine, http.MethodPost, "/api/v1/transmitters", apiTransmitterCreate{Type: "ntfy", Config: json.RawMessage(`{"ServerURL":"https://ntfy.sh","Topic":"alerts"}`)})
+	require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+	var created apiTransmitter
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+	var path = "/api/v1/transmitters/" + strconv.Itoa(created.Id)
+
+	recorder, rejected := requestAPI(engine, http.MethodPut, path, apiTransmitterUpdate{Config: json.RawMessage(`{"ServerURL":"https://ntfy.sh","Topic":"a/b"}`)})
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Contains(t, rejected.ErrorDescription, "topic")
+	assert.Contains(t, string(relay.GetTransmitters()[created.Id].GetStorageValue(created.Id).Config), `"Topic":"alerts"`)
+
+	recorder, _ = requestAPI(engine, http.MethodPut, path, apiTransmitterUpdate{Config: json.RawMessage(`{"ServerURL":"https://ntfy.sh","Topic":"updates"}`)})
+	assert.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	assert.Contains(t, string(relay.GetTransmitters()[created.Id].GetStorageValue(created.Id).Config), `"Topic":"updates"`)
+}
+
+func TestEveryTypeValidatesConfig(t *testing.T) {
+	// Discord transmitters look up their webhook when they are built.
+	var discord = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"Relay"}`))
+	}))
+	t.Cleanup(discord.Close)
+
+	var tests = []struct {
+		transmitterType string
+		valid           string
+		invalid         string
+		rejected        string
+	}{
+		{"discord", `{"WebhookURL":"` + discord.URL + `/api/webhooks/1/token"}`, `{"WebhookURL":""}`, "webhook URL must start with"},
+		{"discord-advance", `{"WebhookURL":"` + discord.URL + `/api/webhooks/1/token"}`, `{"WebhookURL":"discord.com/api/webhooks/1/token"}`, "webhook URL must start with"},
+		{"pushbullet", `{"AccessToken":"o.abc"}`, `{"AccessToken":""}`, "access token is required"},
+		{"telegram", `{"BotToken":"123:abc","ChatID":"-100"}`, `{"BotToken":"123:abc","ChatID":""}`, "bot token and chat ID are required"},
+		{"gotify", `{"ServerURL":"https://gotify.example.org","AppToken":"Aabc"}`, `{"ServerURL":"https://gotify.example.org","AppToken":""}`, "application token is required"},
+		{"matrix", `{"HomeserverURL":"https://matrix.example.org","AccessToken":"syt_abc","RoomID":"!room:example.org"}`, `{"HomeserverURL":"https://matrix.example.org","AccessToken":"syt_abc"}`, "room ID are required"},
+		{"webhook", `{"URL":"https://example.org/hook"}`, `{"URL":"/hook"}`, "absolute http or https URL"},
+		{"slack", `{"WebhookURL":"https://hooks.slack.com/services/T0/B0/x"}`, `{"WebhookURL":""}`, "must start with"},
+		{"ntfy", `{"ServerURL":"https://ntfy.sh","Topic":"alerts"}`, `{"ServerURL":"https://ntfy.sh","Topic":""}`, "topic"},
+		{"smtp", `{"Host":"mail.example.org","Port":587,"Security":"starttls","From":"relay@example.org","To":["me@example.org"]}`, `{"Host":"","Port":587}`, "host is required"},
+	}
+	for _, test := range tests {
+		t.Run(test.transmitterType, func(t *testing.T) {
+			engine, relay := newAPITest(t)
+
+			recorder, rejected := requestAPI(engine, http.MethodPost, "/api/v1/transmitters", apiTransmitterCreate{Type: test.transmitterType, Config: json.RawMessage(test.invalid)})
+			assert.Equal(t, http.StatusBadRequest, recorder.Code, recorder.Body.String())
+			assert.Contains(t, rejected.ErrorDescription, test.rejected)
+			assert.Empty(t, relay.GetTransmitters())
+
+			recorder, _ = requestAPI(engine, http.MethodPost, "/api/v1/transmitters", apiTransmitterCreate{Type: test.transmitterType, Config: json.RawMessage(test.valid)})
+			require.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+			var created apiTransmitter
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &created))
+			var before = relay.GetTransmitters()[created.Id].GetStorageValue(created.Id).Config
+
+			recorder, rejected = requestAPI(engine, http.MethodPut, "/api/v1/transmitters/"+strconv.Itoa(created.Id), apiTransmitterUpdate{Config: json.RawMessage(test.invalid)})
+			assert.Equal(t, http.StatusBadRequest, recorder.Code, recorder.Body.String())
+			assert.Contains(t, rejected.ErrorDescription, test.rejected)
+			assert.Equal(t, before, relay.GetTransmitters()[created.Id].GetStorageValue(created.Id).Config)
+		})
+	}
+}
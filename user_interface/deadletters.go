@@ -0,0 +1,116 @@
+package user_interface
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/CEKlopfenstein/gotify-repeater/relay"
+	"github.com/CEKlopfenstein/gotify-repeater/structs"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed deadletters.html
+var deadLetters string
+
+// Renders the dead letter list along with an optional status message.
+func renderDeadLetters(relay *relay.Relay, logger *slog.Logger, message string, failed bool) []byte {
+	tmpl, err := template.New("").Parse(deadLetters)
+	if err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+
+	type temp struct {
+		Jobs          []structs.OutboxJob
+		DeadLetterIDs []int
+		ExistingIDs   []int
+		Message       string
+		Failed        bool
+	}
+	var data = temp{Jobs: relay.GetDeadLetters(), Message: message, Failed: failed}
+	for _, job := range data.Jobs {
+		if !slices.Contains(data.DeadLetterIDs, job.TransmitterId) {
+			data.DeadLetterIDs = append(data.DeadLetterIDs, job.TransmitterId)
+		}
+	}
+	for id := range relay.GetTransmitters() {
+		data.ExistingIDs = append(data.ExistingIDs, id)
+	}
+	slices.Sort(data.DeadLetterIDs)
+	slices.Sort(data.ExistingIDs)
+
+	var buffer = bytes.Buffer{}
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+	return buffer.Bytes()
+}
+
+func buildDeadLetterRoutes(mux *gin.RouterGroup, relay *relay.Relay, logger *slog.Logger) {
+	mux.GET("/deadletters", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, "", false))
+	})
+
+	mux.DELETE("/deadletters", func(ctx *gin.Context) {
+		var count = relay.PurgeAllDeadLetters()
+		ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, fmt.Sprintf("Purged %d dead letter(s)", count), false))
+	})
+
+	mux.POST("/deadletters/:jobID/replay", func(ctx *gin.Context) {
+		jobId, err := strconv.Atoi(ctx.Param("jobID"))
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, "Invalid ID", true))
+			return
+		}
+		transmitterId, err := strconv.Atoi(ctx.PostForm("transmitter"))
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, "Invalid Transmitter", true))
+			return
+		}
+
+		err = relay.ReplayDeadLetter(jobId, transmitterId)
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, "Replay failed: "+err.Error(), true))
+			return
+		}
+		ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, fmt.Sprintf("Dead letter %d delivered", jobId), false))
+	})
+
+	mux.DELETE("/deadletters/:jobID", func(ctx *gin.Context) {
+		jobId, err := strconv.Atoi(ctx.Param("jobID"))
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, "Invalid ID", true))
+			return
+		}
+		relay.PurgeDeadLetter(jobId)
+		ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, fmt.Sprintf("Dead letter %d purged", jobId), false))
+	})
+
+	mux.POST("/deadletters/transmitter/:transmitterID/replay", func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("transmitterID"))
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, "Invalid Transmitter", true))
+			return
+		}
+		delivered, failed := relay.ReplayDeadLetters(id)
+		ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, fmt.Sprintf("Transmitter %d: %d delivered, %d failed", id, delivered, failed), failed > 0))
+	})
+
+	mux.DELETE("/deadletters/transmitter/:transmitterID", func(ctx *gin.Context) {
+		id, err := strconv.Atoi(ctx.Param("transmitterID"))
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, "Invalid Transmitter", true))
+			return
+		}
+		var count = relay.PurgeDeadLetters(id)
+		ctx.Data(http.StatusOK, "text/html", renderDeadLetters(relay, logger, fmt.Sprintf("Purged %d dead letter(s) for transmitter %d", count, id), false))
+	})
+}
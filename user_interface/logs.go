@@ -0,0 +1,195 @@
+package user_interface
+
+import (
+	"bytes"
+	"cmp"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/CEKlopfenstein/gotify-repeater/logging"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed logs.html
+var logsPage string
+
+// Most log entries returned at once.
+const maxLogLimit = 500
+
+// Interval of the comments sent to keep idle log streams open through proxies.
+const logStreamKeepAlive = 30 * time.Second
+
+// Layout of the datetime-local inputs of the config page. Interpreted in the server's time zone.
+const dateTimeLocal = "2006-01-02T15:04"
+
+func parseLogTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	return time.ParseInLocation(dateTimeLocal, value, time.Local)
+}
+
+// Reads the filters of GET /logs. level is the lowest level shown. since and until are RFC 3339 or datetime-local times.
+// before is the cursor of the page to show.
+func logQueryFromRequest(values url.Values) (logging.Query, error) {
+	var query logging.Query
+	var err error
+	if query.MinLevel, err = logging.ParseLevel(values.Get("level")); err != nil {
+		return query, fmt.Errorf("invalid level: %w", err)
+	}
+	if transmitter := values.Get("transmitter"); len(transmitter) > 0 {
+		if _, err := strconv.Atoi(transmitter); err != nil {
+			return query, fmt.Errorf("invalid transmitter ID %q", transmitter)
+		}
+		query.Transmitter = transmitter
+	}
+	if query.Since, err = parseLogTime(values.Get("since")); err != nil {
+		return query, fmt.Errorf("invalid since: %w", err)
+	}
+	if query.Until, err = parseLogTime(values.Get("until")); err != nil {
+		return query, fmt.Errorf("invalid until: %w", err)
+	}
+	if before := values.Get("before"); len(before) > 0 {
+		if query.Before, err = strconv.ParseUint(before, 10, 64); err != nil {
+			return query, fmt.Errorf("invalid before: %w", err)
+		}
+	}
+	if limit := values.Get("limit"); len(limit) > 0 {
+		if query.Limit, err = strconv.Atoi(limit); err != nil || query.Limit < 1 {
+			return query, fmt.Errorf("invalid limit %q", limit)
+		}
+		query.Limit = min(query.Limit, maxLogLimit)
+	}
+	return query, nil
+}
+
+func logLevelClass(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "text-danger"
+	case level >= slog.LevelWarn:
+		return "text-warning"
+	case level < slog.LevelInfo:
+		return "text-muted"
+	}
+	return "text-info"
+}
+
+// Renders a page of log entries matching the filters of the request. The newest page refreshes itself.
+func renderLogs(logs *logging.Buffer, values url.Values, logger *slog.Logger) []byte {
+	tmpl, err := template.New("").Funcs(template.FuncMap{"levelClass": logLevelClass}).Parse(logsPage)
+	if err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+
+	type temp struct {
+		Page logging.Page
+		// Filters without the page cursor.
+		Query      string
+		OlderQuery string
+		Live       bool
+		Error      string
+	}
+	var data = temp{}
+	query, err := logQueryFromRequest(values)
+	if err != nil {
+		data.Error = err.Error()
+	} else {
+		data.Page = logs.Query(query)
+		data.Live = query.Before == 0
+		var filters = url.Values{}
+		for key, value := range values {
+			if key != "before" {
+				filters[key] = value
+			}
+		}
+		data.Query = filters.Encode()
+		filters.Set("before", strconv.FormatUint(data.Page.Next, 10))
+		data.OlderQuery = filters.Encode()
+	}
+
+	var buffer = bytes.Buffer{}
+	err = tmpl.Execute(&buffer, data)
+	if err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+	return buffer.Bytes()
+}
+
+// Writes the entry as a Server-Sent Event if it matches the query. Its Seq is the event ID.
+func writeLogEvent(writer io.Writer, query logging.Query, entry logging.Entry) error {
+	if !query.Matches(entry) {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "id: %d\nevent: log\ndata: %s\n\n", entry.Seq, data)
+	return err
+}
+
+func buildLogRoutes(mux *gin.RouterGroup, logs *logging.Buffer, logger *slog.Logger) {
+	mux.GET("/logs", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html", renderLogs(logs, ctx.Request.URL.Query(), logger))
+	})
+
+	// Streams new log entries as Server-Sent Events. Takes the level and transmitter filters of GET /logs.
+	// Entries missed since the Seq in the Last-Event-ID header or the after parameter are sent first if still buffered.
+	mux.GET("/logs/stream", func(ctx *gin.Context) {
+		query, err := logQueryFromRequest(ctx.Request.URL.Query())
+		if err != nil {
+			ctx.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		var after uint64 = math.MaxUint64
+		if lastID := cmp.Or(ctx.GetHeader("Last-Event-ID"), ctx.Query("after")); len(lastID) > 0 {
+			if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+				ctx.String(http.StatusBadRequest, "invalid after: "+err.Error())
+				return
+			}
+		}
+
+		backlog, entries, cancel := logs.Subscribe(after)
+		defer cancel()
+		ctx.Header("Content-Type", "text/event-stream")
+		ctx.Header("Cache-Control", "no-cache")
+		// Stops nginx from buffering the stream.
+		ctx.Header("X-Accel-Buffering", "no")
+		ctx.Status(http.StatusOK)
+		for _, entry := range backlog {
+			if err := writeLogEvent(ctx.Writer, query, entry); err != nil {
+				return
+			}
+		}
+		ctx.Writer.Flush()
+
+		var keepAlive = time.NewTicker(logStreamKeepAlive)
+		defer keepAlive.Stop()
+		ctx.Stream(func(writer io.Writer) bool {
+			select {
+			case <-ctx.Request.Context().Done():
+				return false
+			case entry, open := <-entries:
+				return open && writeLogEvent(writer, query, entry) == nil
+			case <-keepAlive.C:
+				_, err := io.WriteString(writer, ": keep-alive\n\n")
+				return err == nil
+			}
+		})
+	})
+}
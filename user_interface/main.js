@@ -1,3 +1,120 @@
 htmx.onLoad((elt) => {
     
-})
\ No newline at end of file
+})
+
+// Most lines kept in the live log pane. Older lines are removed.
+const logTailMaxLines = 500;
+
+// Tails logs/stream into the live log pane. Changing a filter starts over. Pausing closes the stream and resuming
+// picks up after the last shown entry as long as the plugin still has it buffered.
+const logTail = {
+    source: null,
+    lastSeq: 0,
+    paused: false,
+
+    url(resume) {
+        const params = new URLSearchParams();
+        params.set("level", document.getElementById("log-tail-level").value);
+        const transmitter = document.getElementById("log-tail-transmitter").value;
+        if (transmitter !== "") {
+            params.set("transmitter", transmitter);
+        }
+        if (resume && this.lastSeq > 0) {
+            params.set("after", this.lastSeq);
+        }
+        return "logs/stream?" + params.toString();
+    },
+
+    connect(resume) {
+        this.disconnect();
+        this.source = new EventSource(this.url(resume));
+        this.source.addEventListener("log", (event) => {
+            const entry = JSON.parse(event.data);
+            this.lastSeq = entry.Seq;
+            this.append(entry);
+        });
+        this.source.onopen = () => this.setStatus("Connected");
+        this.source.onerror = () => this.setStatus("Reconnecting...");
+    },
+
+    disconnect() {
+        if (this.source !== null) {
+            this.source.close();
+            this.source = null;
+        }
+    },
+
+    setStatus(status) {
+        document.getElementById("log-tail-status").textContent = status;
+    },
+
+    append(entry) {
+        const pane = document.getElementById("log-tail");
+        const following = pane.scrollTop + pane.clientHeight >= pane.scrollHeight - 5;
+
+        const line = document.createElement("div");
+        const level = document.createElement("span");
+        level.className = logLevelClass(entry.Level);
+        level.textContent = entry.Level.padEnd(5);
+        let text = " " + entry.Message;
+        for (const attr of entry.Attrs || []) {
+            text += " " + attr.Key + "=" + attr.Value;
+        }
+        line.append(formatLogTime(new Date(entry.Time)) + " ", level, text);
+        pane.append(line);
+
+        while (pane.childElementCount > logTailMaxLines) {
+            pane.firstElementChild.remove();
+        }
+        if (following) {
+            pane.scrollTop = pane.scrollHeight;
+        }
+    },
+};
+
+function logLevelClass(level) {
+    if (level.startsWith("ERROR")) {
+        return "text-danger";
+    }
+    if (level.startsWith("WARN")) {
+        return "text-warning";
+    }
+    if (level.startsWith("DEBUG")) {
+        return "text-muted";
+    }
+    return "text-info";
+}
+
+function formatLogTime(date) {
+    const pad = (value) => String(value).padStart(2, "0");
+    return date.getFullYear() + "-" + pad(date.getMonth() + 1) + "-" + pad(date.getDate()) + " " +
+        pad(date.getHours()) + ":" + pad(date.getMinutes()) + ":" + pad(date.getSeconds());
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+    const pause = document.getElementById("log-tail-pause");
+    const restart = () => {
+        document.getElementById("log-tail").replaceChildren();
+        logTail.lastSeq = 0;
+        if (!logTail.paused) {
+            logTail.connect(false);
+        }
+    };
+
+    document.getElementById("log-tail-level").addEventListener("change", restart);
+    document.getElementById("log-tail-transmitter").addEventListener("change", restart);
+    document.getElementById("log-tail-clear").addEventListener("click", () => {
+        document.getElementById("log-tail").replaceChildren();
+    });
+    pause.addEventListener("click", () => {
+        logTail.paused = !logTail.paused;
+        pause.textContent = logTail.paused ? "Resume" : "Pause";
+        if (logTail.paused) {
+            logTail.disconnect();
+            logTail.setStatus("Paused");
+        } else {
+            logTail.connect(true);
+        }
+    });
+    logTail.connect(false);
+});
\ No newline at end of file
@@ -0,0 +1,46 @@
+package user_interface
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/CEKlopfenstein/gotify-repeater/relay"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ginParameter = regexp.MustCompile(`:([^/]+)`)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var engine = gin.New()
+	var relay relay.Relay
+	BuildInterface("/plugin", engine.Group("/plugin"), &relay, nil, nil, "http://127.0.0.1", slog.New(slog.NewTextHandler(io.Discard, nil)), nil)
+
+	var registered []string
+	for _, route := range engine.Routes() {
+		var path = ginParameter.ReplaceAllString(strings.TrimPrefix(route.Path, "/plugin"), "{$1}")
+		registered = append(registered, route.Method+" "+path)
+	}
+
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]json.RawMessage
+	}
+	require.NoError(t, json.Unmarshal([]byte(openAPISpec), &spec))
+	assert.True(t, strings.HasPrefix(spec.OpenAPI, "3."), "not an OpenAPI 3 document")
+
+	var documented []string
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	assert.ElementsMatch(t, registered, documented, "routes registered by BuildInterface and paths in openapi.json differ")
+}
@@ -0,0 +1,57 @@
+package user_interface
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+
+	"github.com/CEKlopfenstein/gotify-repeater/relay"
+	"github.com/CEKlopfenstein/gotify-repeater/storage"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed secrets.html
+var secrets string
+
+// Renders the state of the key stored secrets are encrypted with along with an optional status message.
+func renderSecrets(logger *slog.Logger, message string, failed bool) []byte {
+	tmpl, err := template.New("").Parse(secrets)
+	if err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+
+	type temp struct {
+		Status  storage.SecretKeyStatus
+		Message string
+		Failed  bool
+	}
+
+	var buffer = bytes.Buffer{}
+	err = tmpl.Execute(&buffer, temp{Status: storage.GetSecretKeyStatus(), Message: message, Failed: failed})
+	if err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+	return buffer.Bytes()
+}
+
+func buildSecretRoutes(mux *gin.RouterGroup, relay *relay.Relay, logger *slog.Logger) {
+	mux.GET("/secrets", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html", renderSecrets(logger, "", false))
+	})
+
+	mux.POST("/secrets/rotate", func(ctx *gin.Context) {
+		id, err := storage.RotateSecretKey()
+		if err != nil {
+			logger.Error(err.Error())
+			ctx.Data(http.StatusOK, "text/html", renderSecrets(logger, "Rotation failed: "+err.Error(), true))
+			return
+		}
+		relay.ReencryptSecrets()
+		logger.Info("Rotated secret key. Secrets are now encrypted with key " + id)
+		ctx.Data(http.StatusOK, "text/html", renderSecrets(logger, "Secrets are now encrypted with key "+id, false))
+	})
+}
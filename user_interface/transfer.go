@@ -0,0 +1,138 @@
+package user_interface
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/CEKlopfenstein/gotify-repeater/relay"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed transfer.html
+var transferResult string
+
+// Largest import accepted.
+const maxImportSize = 1 << 20
+
+// Encodes the export as indented JSON or as YAML. YAML is converted from the JSON encoding so both use the same keys.
+func encodeExport(export relay.ConfigExport, format string) ([]byte, error) {
+	encoded, err := json.MarshalIndent(export, "", "  ")
+	if err != nil || format != "yaml" {
+		return encoded, err
+	}
+	var generic any
+	if err := json.Unmarshal(encoded, &generic); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(generic)
+}
+
+// Decodes an export encoded as either JSON or YAML. JSON is valid YAML so both are read the same way.
+func decodeExport(data []byte) (relay.ConfigExport, error) {
+	var export relay.ConfigExport
+	var generic any
+	if err := yaml.Unmarshal(data, &generic); err != nil {
+		return export, err
+	}
+	encoded, err := json.Marshal(generic)
+	if err != nil {
+		return export, err
+	}
+	err = json.Unmarshal(encoded, &export)
+	return export, err
+}
+
+// Renders the outcome of an import.
+func renderImportResult(logger *slog.Logger, result relay.ImportResult, dryRun bool, err error) []byte {
+	tmpl, parseErr := template.New("").Parse(transferResult)
+	if parseErr != nil {
+		logger.Error(parseErr.Error())
+		return []byte(parseErr.Error())
+	}
+
+	type temp struct {
+		Result relay.ImportResult
+		DryRun bool
+		Error  string
+	}
+	var data = temp{Result: result, DryRun: dryRun}
+	if err != nil {
+		data.Error = err.Error()
+	}
+
+	var buffer = bytes.Buffer{}
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+	return buffer.Bytes()
+}
+
+func buildTransferRoutes(mux *gin.RouterGroup, relay *relay.Relay, logger *slog.Logger) {
+	// Secrets are redacted unless redact=false is given.
+	mux.GET("/export", func(ctx *gin.Context) {
+		var format = ctx.DefaultQuery("format", "json")
+		if format != "json" && format != "yaml" {
+			ctx.String(http.StatusBadRequest, "Unknown format. Use json or yaml")
+			return
+		}
+
+		export, err := relay.Export(ctx.Query("redact") != "false")
+		if err != nil {
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		encoded, err := encodeExport(export, format)
+		if err != nil {
+			logger.Error(err.Error())
+			ctx.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		var contentType = "application/json"
+		if format == "yaml" {
+			contentType = "application/yaml"
+		}
+		ctx.Header("Content-Disposition", `attachment; filename="gotify-relay-export.`+format+`"`)
+		ctx.Data(http.StatusOK, contentType, encoded)
+	})
+
+	// Reads the export from an uploaded file or from the data field. Changes nothing when dry-run=true is given.
+	mux.POST("/import", func(ctx *gin.Context) {
+		var dryRun = ctx.Query("dry-run") == "true" || ctx.PostForm("dry-run") == "true"
+		result, err := importFromForm(ctx, relay, dryRun)
+		ctx.Data(http.StatusOK, "text/html", renderImportResult(logger, result, dryRun, err))
+	})
+}
+
+func importFromForm(ctx *gin.Context, target *relay.Relay, dryRun bool) (relay.ImportResult, error) {
+	var data = []byte(ctx.PostForm("data"))
+	if file, err := ctx.FormFile("file"); err == nil {
+		opened, err := file.Open()
+		if err != nil {
+			return relay.ImportResult{}, err
+		}
+		defer opened.Close()
+		data, err = io.ReadAll(io.LimitReader(opened, maxImportSize))
+		if err != nil {
+			return relay.ImportResult{}, err
+		}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return relay.ImportResult{}, errors.New("nothing to import. Choose a file or paste an export")
+	}
+
+	export, err := decodeExport(data)
+	if err != nil {
+		return relay.ImportResult{}, fmt.Errorf("invalid export: %w", err)
+	}
+	return target.Import(export, relay.ImportMode(ctx.DefaultPostForm("mode", string(relay.ImportMerge))), dryRun)
+}
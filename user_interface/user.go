@@ -7,11 +7,16 @@ import (
 	"encoding/base64"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/CEKlopfenstein/gotify-repeater/filters"
 	"github.com/CEKlopfenstein/gotify-repeater/gotify_api"
+	"github.com/CEKlopfenstein/gotify-repeater/logging"
+	"github.com/CEKlopfenstein/gotify-repeater/metrics"
 	"github.com/CEKlopfenstein/gotify-repeater/relay"
 	"github.com/CEKlopfenstein/gotify-repeater/storage"
 	"github.com/CEKlopfenstein/gotify-repeater/structs"
@@ -34,6 +39,14 @@ var transmitterSelect string
 //go:embed bootstrap.min.css
 var bootstrap string
 
+//go:embed settings.html
+var settingsForm string
+
+// Describes every route registered by BuildInterface. Checked against the registered routes by TestOpenAPIMatchesRoutes.
+//
+//go:embed openapi.json
+var openAPISpec string
+
 type userPage struct {
 	HtmxBasePath string
 	Cards        []card
@@ -46,7 +59,7 @@ type card struct {
 	Body  template.HTML
 }
 
-func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, hookConfig *structs.Config, c storage.Storage, hostname string, logger *log.Logger, logBuffer *bytes.Buffer) {
+func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, hookConfig *structs.Config, c *storage.Storage, hostname string, logger *slog.Logger, logs *logging.Buffer) {
 	var cards = []card{}
 	var pageData = userPage{HtmxBasePath: "htmx.min.js", Cards: cards, MainJSPath: "main.js", Bootstrap: "bootstrap.min.css"}
 
@@ -59,64 +72,56 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 	mux.GET("/"+pageData.Bootstrap, func(ctx *gin.Context) {
 		ctx.Data(http.StatusOK, "text/css", []byte(bootstrap))
 	})
-
-	mux.GET("/", func(ctx *gin.Context) {
-		var cookie, cookieError = ctx.Request.Cookie("gotify-client-token")
-		var clientKey = cookie.Value
-		if cookieError != nil {
-			logger.Println(cookieError)
-			ctx.Data(http.StatusUnauthorized, "text/html", []byte("Failed to parse/get gotify-client-token Cookie"))
-			ctx.Done()
-		} else if len(clientKey) == 0 {
-			logger.Println("gotify-client-token Cookie Missing")
-			ctx.Data(http.StatusUnauthorized, "text/html", []byte("gotify-client-token Cookie Missing"))
-			ctx.Done()
-		} else {
-			var server = relay.GetGotifyApi()
-			var failed = server.CheckToken(clientKey)
-			if failed != nil {
-				logger.Println(failed)
-				ctx.Data(http.StatusOK, "text/html", []byte("<h2>Unauthorized token. Redirecting to main page.</h2><script>window.location = '/';</script>"))
-				ctx.Done()
-				return
-			}
-			tmpl, err := template.New("").Parse(main)
-			if err != nil {
-				logger.Println(err)
-				ctx.Done()
-				return
-			}
-			err = tmpl.Execute(ctx.Writer, pageData)
-			if err != nil {
-				logger.Println(err)
-			}
-		}
-
+	mux.GET("/openapi.json", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "application/json", []byte(openAPISpec))
 	})
 
-	internalGotifyApi := gotify_api.SetupGotifyApi(hostname, "")
-	mux.Use(func(ctx *gin.Context) {
-		var cookie, _ = ctx.Request.Cookie("gotify-client-token")
-		var clientKey = cookie.Value
+	mux.GET("/", func(ctx *gin.Context) {
+		var clientKey = requestToken(ctx)
 		if len(clientKey) == 0 {
-			ctx.Data(http.StatusUnauthorized, "text/html", []byte("X-Gotify-Key Missing"))
-			ctx.Done()
+			logger.Warn("gotify-client-token Cookie Missing")
+			ctx.Data(http.StatusUnauthorized, "text/html", []byte("gotify-client-token Cookie Missing"))
 			return
 		}
 
-		var failed = internalGotifyApi.UpdateToken(clientKey)
+		var server = relay.GetGotifyApi()
+		var failed = server.CheckToken(clientKey)
 		if failed != nil {
-			logger.Println(failed)
-			ctx.Data(http.StatusUnauthorized, "application/json", []byte(failed.Error()))
-			ctx.Done()
+			logger.Warn(failed.Error())
+			ctx.Data(http.StatusOK, "text/html", []byte("<h2>Unauthorized token. Redirecting to main page.</h2><script>window.location = '/';</script>"))
 			return
 		}
-		ctx.Set("token", clientKey)
-		ctx.Next()
+		tmpl, err := template.New("").Parse(main)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		err = tmpl.Execute(ctx.Writer, pageData)
+		if err != nil {
+			logger.Error(err.Error())
+		}
 	})
 
-	mux.GET("/logs", func(ctx *gin.Context) {
-		ctx.Data(http.StatusOK, "text/html", logBuffer.Bytes())
+	internalGotifyApi := gotify_api.SetupGotifyApi(hostname, "")
+
+	// Registered before the HTML middleware so API requests are rejected with JSON errors instead.
+	buildAPIRoutes(mux.Group("/api/v1", tokenMiddleware(&internalGotifyApi, logger, rejectAPI)), relay, c, logger)
+
+	mux.Use(tokenMiddleware(&internalGotifyApi, logger, func(ctx *gin.Context, status int, message string) {
+		ctx.Data(status, "text/html", []byte(message))
+	}))
+
+	buildLogRoutes(mux, logs, logger)
+
+	mux.GET("/relay-state", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html", []byte(template.HTMLEscapeString(relay.GetStreamStatus().String())))
+	})
+
+	mux.GET("/metrics", func(ctx *gin.Context) {
+		ctx.Header("Content-Type", metrics.ContentType)
+		if err := relay.WriteMetrics(ctx.Writer); err != nil {
+			logger.Error(err.Error())
+		}
 	})
 
 	mux.GET("/getLoginToken", func(ctx *gin.Context) {
@@ -140,20 +145,19 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 		var transmitter = transmitters[intId]
 		if transmitter == nil {
 			ctx.Data(http.StatusNotFound, "text/html", []byte("Invalid ID"))
+			ctx.Abort()
 			return
 		}
 
 		ctx.Set("transID", intId)
+		// Kept so handlers still have it if the transmitter is removed concurrently.
+		ctx.Set("transmitter", transmitter)
 		ctx.Next()
 	})
 
 	transmitterGroup.GET("/", func(ctx *gin.Context) {
-		var transmitter transmitters.Transmitter
 		var id = ctx.GetInt("transID")
-
-		var transmitters = relay.GetTransmitters()
-		transmitter = transmitters[id]
-		logger.Println(transmitter)
+		var transmitter = ctx.MustGet("transmitter").(transmitters.Transmitter)
 
 		ctx.Data(http.StatusOK, "text/html", []byte(transmitter.HTMLCard(id)))
 	})
@@ -176,8 +180,7 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 	})
 
 	transmitterGroup.GET("/count", func(ctx *gin.Context) {
-		var id = ctx.GetInt("transID")
-		var transmitCount = relay.GetTransmitters()[id].GetTransmitCount()
+		var transmitCount = ctx.MustGet("transmitter").(transmitters.Transmitter).GetTransmitCount()
 		if transmitCount == -1 {
 			ctx.Data(http.StatusNotImplemented, "text/html", []byte("Selected transmitter does not implement transmition count."))
 		} else {
@@ -185,6 +188,99 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 		}
 	})
 
+	transmitterGroup.GET("/edit", func(ctx *gin.Context) {
+		var id = ctx.GetInt("transID")
+		var stored = ctx.MustGet("transmitter").(transmitters.Transmitter).GetStorageValue(id)
+		var function = transmitters.Types[stored.TransmitterType].EditPage
+		if function == nil {
+			ctx.Data(http.StatusNotImplemented, "text/html", []byte("Selected transmitter does not have any settings to edit."))
+			return
+		}
+		ctx.Data(http.StatusOK, "text/html", function(stored))
+	})
+
+	transmitterGroup.PUT("/", func(ctx *gin.Context) {
+		var id = ctx.GetInt("transID")
+		var stored = ctx.MustGet("transmitter").(transmitters.Transmitter).GetStorageValue(id)
+		var function = transmitters.Types[stored.TransmitterType].EditPutHandler
+		if function == nil {
+			ctx.Data(http.StatusNotImplemented, "text/html", []byte("Selected transmitter does not have any settings to edit."))
+			return
+		}
+		ctx.Data(http.StatusOK, "text/html", function(ctx, stored, func(transmitter structs.TransmitterStorage) error {
+			return relay.UpdateTransmitter(id, transmitter)
+		}))
+	})
+
+	transmitterGroup.GET("/filters", func(ctx *gin.Context) {
+		var id = ctx.GetInt("transID")
+		if err := relay.GetTransmitterFilterError(id); err != nil {
+			var message = "Nothing is relayed until these filters are fixed: " + err.Error()
+			ctx.Data(http.StatusOK, "text/html", []byte(filters.HTMLForm(id, relay.GetTransmitterFilters(id), message, true)))
+			return
+		}
+		ctx.Data(http.StatusOK, "text/html", []byte(filters.HTMLForm(id, relay.GetTransmitterFilters(id), "", false)))
+	})
+
+	transmitterGroup.PUT("/filters", func(ctx *gin.Context) {
+		var id = ctx.GetInt("transID")
+
+		settings, err := filters.ParseForm(ctx)
+		if err == nil {
+			err = relay.SetTransmitterFilters(id, settings)
+		}
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", []byte(filters.HTMLForm(id, settings, err.Error(), true)))
+			return
+		}
+
+		ctx.Data(http.StatusOK, "text/html", []byte(filters.HTMLForm(id, relay.GetTransmitterFilters(id), "Filters Saved", false)))
+	})
+
+	transmitterGroup.POST("/preview", func(ctx *gin.Context) {
+		var id = ctx.GetInt("transID")
+		preview, err := relay.PreviewTransmitter(id, sampleMessage())
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", []byte(`<div class="text-danger text-break">`+template.HTMLEscapeString(err.Error())+`</div>`))
+			return
+		}
+		ctx.Data(http.StatusOK, "text/html", []byte(`<pre class="text-break" style="white-space: pre-wrap;">`+template.HTMLEscapeString(preview)+`</pre>`))
+	})
+
+	transmitterGroup.GET("/test", func(ctx *gin.Context) {
+		var id = ctx.GetInt("transID")
+		ctx.Data(http.StatusOK, "text/html", renderTestForm(id, sampleMessage(), logger))
+	})
+
+	transmitterGroup.POST("/test", func(ctx *gin.Context) {
+		var id = ctx.GetInt("transID")
+		var msg = sampleMessage()
+		msg.Title = ctx.DefaultPostForm("title", msg.Title)
+		msg.Message = ctx.DefaultPostForm("message", msg.Message)
+		if priority := ctx.PostForm("priority"); len(priority) > 0 {
+			var err error
+			msg.Priority, err = strconv.Atoi(priority)
+			if err != nil {
+				ctx.Data(http.StatusOK, "text/html", []byte(`<div class="text-danger text-break">Invalid Priority: `+template.HTMLEscapeString(priority)+`</div>`))
+				return
+			}
+		}
+
+		status, err := relay.TestTransmitter(id, msg)
+		if err != nil {
+			ctx.Data(http.StatusOK, "text/html", []byte(`<div class="text-danger text-break">Test Failed: `+template.HTMLEscapeString(err.Error())+`</div>`))
+			return
+		}
+		if len(status) == 0 {
+			status = "No response status reported"
+		}
+		ctx.Data(http.StatusOK, "text/html", []byte(`<div class="text-success text-break">Test Sent Successfully. Response: `+template.HTMLEscapeString(status)+`</div>`))
+	})
+
+	buildDeadLetterRoutes(mux, relay, logger)
+	buildSecretRoutes(mux, relay, logger)
+	buildTransferRoutes(mux, relay, logger)
+
 	mux.GET("/transmitter-options", func(ctx *gin.Context) {
 		tmpl, _ := template.New("").Parse(transmitterSelect)
 		var buffer bytes.Buffer
@@ -219,6 +315,42 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 		ctx.Data(http.StatusBadRequest, "text/html", []byte("<div>Invalid Transmitter Type Selected</div>"))
 	})
 
+	renderSettings := func(settings storage.Settings, message string, failed bool) []byte {
+		tmpl, err := template.New("").Parse(settingsForm)
+		if err != nil {
+			logger.Error(err.Error())
+			return []byte(err.Error())
+		}
+		type temp struct {
+			Settings storage.Settings
+			Message  string
+			Failed   bool
+		}
+		var buffer bytes.Buffer
+		err = tmpl.Execute(&buffer, temp{Settings: settings, Message: message, Failed: failed})
+		if err != nil {
+			logger.Error(err.Error())
+			return []byte(err.Error())
+		}
+		return buffer.Bytes()
+	}
+
+	mux.GET("/settings", func(ctx *gin.Context) {
+		ctx.Data(http.StatusOK, "text/html", renderSettings(c.GetSettings(), "", false))
+	})
+
+	mux.PUT("/settings", func(ctx *gin.Context) {
+		var settings = c.GetSettings()
+		maxBackfillMinutes, err := strconv.Atoi(ctx.PostForm("max-backfill-minutes"))
+		if err != nil || maxBackfillMinutes < 0 {
+			ctx.Data(http.StatusOK, "text/html", renderSettings(settings, "Catch up age must be zero or more minutes", true))
+			return
+		}
+		settings.MaxBackfillMinutes = maxBackfillMinutes
+		c.SaveSettings(settings)
+		ctx.Data(http.StatusOK, "text/html", renderSettings(settings, "Settings Saved", false))
+	})
+
 	mux.GET("/defaultToken", func(ctx *gin.Context) {
 		var token = c.GetClientToken()
 		if len(token) == 0 || token == "null" {
@@ -239,7 +371,7 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 	mux.PUT("/defaultToken", func(ctx *gin.Context) {
 		var token = ctx.PostForm("token")
 
-		logger.Println("Hello world")
+		logger.Debug("Hello world")
 		if token == "new" {
 			clientToken := c.GetClientToken()
 			h := sha256.New()
@@ -247,7 +379,7 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 			expectedClientName := "Relay Client " + base64.StdEncoding.EncodeToString([]byte(h.Sum(nil)))[:16]
 			if internalGotifyApi.CheckToken(clientToken) == nil {
 				client := internalGotifyApi.FindClientFromName(expectedClientName)
-				logger.Println(client.Name)
+				logger.Debug("Found previous relay client", "name", client.Name)
 				if len(client.Name) != 0 {
 					// Round about method of "deleting" old clients. (Gotify may be updated later causing this to fail.)
 					internalGotifyApi.UpdateClient(client.Id, "Old Relay Client: Will Delete In 10 Seconds", 10)
@@ -255,7 +387,7 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 			}
 			newClient, err := internalGotifyApi.CreateClient("Relay Client")
 			if err != nil {
-				logger.Println(err)
+				logger.Error(err.Error())
 				ctx.Redirect(303, "defaultToken")
 				return
 			}
@@ -272,3 +404,76 @@ func BuildInterface(basePath string, mux *gin.RouterGroup, relay *relay.Relay, h
 		ctx.Redirect(303, "defaultToken")
 	})
 }
+
+// Reads the client token from the X-Gotify-Key header or the gotify-client-token cookie set by Gotify's web UI.
+func requestToken(ctx *gin.Context) string {
+	if token := ctx.GetHeader("X-Gotify-Key"); len(token) > 0 {
+		return token
+	}
+	// Used by scrapers such as Prometheus that can only send a bearer token.
+	if token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer "); found && len(token) > 0 {
+		return token
+	}
+	if cookie, err := ctx.Request.Cookie("gotify-client-token"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// Rejects requests without a valid client token. The token is kept in the context as "token".
+// reject writes the response sent for rejected requests.
+func tokenMiddleware(gotifyApi *gotify_api.GotifyApi, logger *slog.Logger, reject func(ctx *gin.Context, status int, message string)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var clientKey = requestToken(ctx)
+		if len(clientKey) == 0 {
+			reject(ctx, http.StatusUnauthorized, "X-Gotify-Key Missing")
+			ctx.Abort()
+			return
+		}
+
+		var failed = gotifyApi.UpdateToken(clientKey)
+		if failed != nil {
+			logger.Warn(failed.Error())
+			reject(ctx, http.StatusUnauthorized, failed.Error())
+			ctx.Abort()
+			return
+		}
+		ctx.Set("token", clientKey)
+		ctx.Next()
+	}
+}
+
+//go:embed test.html
+var testForm string
+
+// Renders the form for sending a test notification through a transmitter.
+func renderTestForm(id int, msg structs.GotifyMessageStruct, logger *slog.Logger) []byte {
+	tmpl, err := template.New("").Parse(testForm)
+	if err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+
+	type temp struct {
+		ID      int
+		Message structs.GotifyMessageStruct
+	}
+
+	var buffer = bytes.Buffer{}
+	err = tmpl.Execute(&buffer, temp{ID: id, Message: msg})
+	if err != nil {
+		logger.Error(err.Error())
+		return []byte(err.Error())
+	}
+	return buffer.Bytes()
+}
+
+// Message used when previewing or testing a transmitter.
+func sampleMessage() structs.GotifyMessageStruct {
+	return structs.GotifyMessageStruct{
+		Date:     time.Now().Format(time.RFC3339),
+		Title:    "Test Notification",
+		Message:  "This is a test notification sent from Gotify Relay.",
+		Priority: 5,
+	}
+}